@@ -0,0 +1,324 @@
+package ultraclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type bulkheadKey struct{}
+
+// WithBulkhead returns a context which performs the work with it in the named
+// bulkhead, the work shares the concurrency of the bulkhead with other work
+// in the same partition rather than with every caller of the client
+// ctx := ultraclient.WithBulkhead(ctx, "reporting")
+// err := client.DoContext(ctx, work)
+func WithBulkhead(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, bulkheadKey{}, name)
+}
+
+// BulkheadFromContext returns the name of the bulkhead given to the context,
+// ok is false when the work has not been given a bulkhead
+func BulkheadFromContext(ctx context.Context) (name string, ok bool) {
+	name, ok = ctx.Value(bulkheadKey{}).(string)
+	return name, ok
+}
+
+var errBulkheadFull = errors.New(ErrorBulkheadFull)
+
+type bulkheadSlotKey struct{}
+
+// enterBulkhead takes a slot from the bulkhead for a call to the client,
+// every attempt of the call shares the slot.  The returned function must be
+// called when the call returns, the slot is released once the call and the
+// work of each of its attempts have returned.  Calls rejected by the bulkhead
+// are not retried.
+func (c *ClientImpl) enterBulkhead(ctx context.Context) (context.Context, func(), error) {
+	name, partition, err := c.acquireBulkhead(ctx)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	if partition == nil {
+		return ctx, func() {}, nil
+	}
+
+	slot := &bulkheadSlot{bulkheads: c.bulkheads, name: name, partition: partition, holders: 1}
+	return context.WithValue(ctx, bulkheadSlotKey{}, slot), slot.done, nil
+}
+
+// acquireBulkhead takes a slot from the bulkhead of the partition given to
+// the context, waiting up to the queue timeout when the bulkhead is full
+func (c *ClientImpl) acquireBulkhead(ctx context.Context) (string, *bulkhead, error) {
+	if c.bulkheads == nil {
+		return "", nil, nil
+	}
+
+	name, ok := BulkheadFromContext(ctx)
+	if !ok {
+		return "", nil, nil
+	}
+
+	partition := c.bulkheads.partition(name)
+	if partition == nil {
+		return name, nil, nil
+	}
+
+	tags := append(c.statsTags(nil), "bulkhead:"+name)
+
+	startTime := time.Now()
+	err := partition.acquire(ctx)
+	c.timingTaggedStats(tags, time.Now().Sub(startTime), StatsBulkheadWait)
+
+	if err != nil {
+		c.bulkheads.leave(name, partition)
+	}
+
+	switch err {
+	case nil:
+		return name, partition, nil
+	case errBulkheadFull:
+		c.incrementTaggedStats(tags, StatsBulkheadFull)
+		return name, nil, ClientError{Message: ErrorBulkheadFull, Err: NonRetryable(err)}
+	default:
+		return name, nil, ClientError{Message: err.Error(), Err: err}
+	}
+}
+
+// bulkheadSlot is the slot taken by a call, it is held by the call and by
+// the attempts of the call which are in flight
+type bulkheadSlot struct {
+	sync.Mutex
+	bulkheads *bulkheads
+	name      string
+	partition *bulkhead
+	holders   int
+}
+
+// bulkheadSlotFromContext returns the slot of the call, nil when the call is
+// not in a bulkhead
+func bulkheadSlotFromContext(ctx context.Context) *bulkheadSlot {
+	slot, _ := ctx.Value(bulkheadSlotKey{}).(*bulkheadSlot)
+	return slot
+}
+
+// hold adds a holder to the slot, it must be called while the call or
+// another holder still holds the slot
+func (s *bulkheadSlot) hold() *bulkheadHold {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.holders++
+	return &bulkheadHold{slot: s}
+}
+
+// done removes a holder of the slot, the slot is returned to the bulkhead
+// when the last holder is done
+func (s *bulkheadSlot) done() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.holders--
+	if s.holders == 0 {
+		s.partition.release()
+		s.bulkheads.leave(s.name, s.partition)
+	}
+}
+
+// bulkheadHold is the share of the slot held by an attempt.  When hystrix
+// times out the attempt the work is left running, the share is kept until the
+// work returns and work which has not yet started is not performed.  The
+// goroutines started by DoAll and DoQuorum also hold a share so the slot is
+// kept until every endpoint has been tried.
+type bulkheadHold struct {
+	sync.Mutex
+	slot     *bulkheadSlot
+	working  bool
+	released bool
+}
+
+// workStarted is called before the work is performed, it returns false when
+// the attempt has finished and the work must not be performed
+func (h *bulkheadHold) workStarted() bool {
+	if h == nil {
+		return true
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if h.released {
+		return false
+	}
+
+	h.working = true
+	return true
+}
+
+// workDone is called when the work returns
+func (h *bulkheadHold) workDone() {
+	if h == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.release()
+}
+
+// finish is called when the attempt has its result, the share is released
+// unless the work is still running
+func (h *bulkheadHold) finish() {
+	if h == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.working {
+		h.release()
+	}
+}
+
+// release returns the share to the slot, the caller must hold the lock
+func (h *bulkheadHold) release() {
+	if h.released {
+		return
+	}
+
+	h.released = true
+	h.slot.done()
+}
+
+// bulkheads holds a bulkhead for each partition, it is shared between a
+// client and its clones.  The bulkheads of configured partitions are kept for
+// the life of the client, the bulkhead of a partition which uses the defaults
+// is only kept while it is in use so that the names given to WithBulkhead do
+// not grow the map without bound.
+type bulkheads struct {
+	sync.Mutex
+	defaults   Bulkhead
+	partitions map[string]*bulkhead
+	defaulted  map[string]*bulkhead
+}
+
+func newBulkheads(config map[string]Bulkhead, defaults Bulkhead) *bulkheads {
+	if len(config) == 0 && defaults.MaxConcurrentRequests <= 0 {
+		return nil
+	}
+
+	b := &bulkheads{
+		defaults:   defaults,
+		partitions: make(map[string]*bulkhead, len(config)),
+		defaulted:  make(map[string]*bulkhead),
+	}
+
+	// a configured partition without a limit is not limited by the defaults
+	for name, partition := range config {
+		b.partitions[name] = newBulkhead(partition)
+	}
+
+	return b
+}
+
+// partition returns the bulkhead for the name, nil when the partition is not
+// limited.  Each call must be followed by a call to leave once the bulkhead
+// is no longer used.
+func (b *bulkheads) partition(name string) *bulkhead {
+	b.Lock()
+	defer b.Unlock()
+
+	if partition, ok := b.partitions[name]; ok {
+		return partition
+	}
+
+	if b.defaults.MaxConcurrentRequests <= 0 {
+		return nil
+	}
+
+	partition, ok := b.defaulted[name]
+	if !ok {
+		partition = newBulkhead(b.defaults)
+		b.defaulted[name] = partition
+	}
+
+	partition.users++
+	return partition
+}
+
+// leave is called when the bulkhead returned from partition is no longer
+// used, the bulkhead of a partition which uses the defaults is removed once
+// nothing uses it
+func (b *bulkheads) leave(name string, partition *bulkhead) {
+	b.Lock()
+	defer b.Unlock()
+
+	if partition == nil || b.defaulted[name] != partition {
+		return
+	}
+
+	partition.users--
+	if partition.users == 0 {
+		delete(b.defaulted, name)
+	}
+}
+
+// bulkhead bounds the work in flight for a partition
+type bulkhead struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	// users is the number of calls using a bulkhead created from the
+	// defaults, guarded by the lock of bulkheads
+	users int
+}
+
+// newBulkhead creates the bulkhead for the config, nil when the config does
+// not limit the partition
+func newBulkhead(config Bulkhead) *bulkhead {
+	if config.MaxConcurrentRequests <= 0 {
+		return nil
+	}
+
+	return &bulkhead{
+		slots:        make(chan struct{}, config.MaxConcurrentRequests),
+		queueTimeout: config.QueueTimeout,
+	}
+}
+
+func (b *bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if b.queueTimeout <= 0 {
+		return errBulkheadFull
+	}
+
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return errBulkheadFull
+	}
+}
+
+func (b *bulkhead) release() {
+	if b != nil {
+		<-b.slots
+	}
+}
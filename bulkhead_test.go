@@ -0,0 +1,183 @@
+package ultraclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupBulkheads(config map[string]Bulkhead, defaults Bulkhead) {
+	client.bulkheads = newBulkheads(config, defaults)
+}
+
+func TestBulkheadRejectsWorkWhenPartitionIsFull(t *testing.T) {
+	setupClient(2)
+	setupBulkheads(map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 1}}, Bulkhead{})
+
+	// work already in flight holds the only slot
+	client.bulkheads.partition("reporting").acquire(context.Background())
+
+	err := client.DoContext(WithBulkhead(context.Background(), "reporting"), noopWork)
+
+	assert.Equal(t, ErrorBulkheadFull, err.(ClientError).Message)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.bulkheadfull", append(client.config.StatsD.Tags, "bulkhead:reporting"), mock.Anything)
+	mockStats.AssertNotCalled(t, "Increment", "myapp.called", mock.Anything, mock.Anything)
+}
+
+func TestBulkheadDoesNotLimitOtherPartitions(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{
+		"reporting": {MaxConcurrentRequests: 1},
+		"checkout":  {MaxConcurrentRequests: 1},
+	}, Bulkhead{})
+	client.bulkheads.partition("reporting").acquire(context.Background())
+
+	assert.Nil(t, client.DoContext(WithBulkhead(context.Background(), "checkout"), noopWork))
+	assert.Nil(t, client.DoContext(context.Background(), noopWork))
+}
+
+func TestBulkheadWaitsForSlotWithinQueueTimeout(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{
+		"reporting": {MaxConcurrentRequests: 1, QueueTimeout: 1 * time.Second},
+	}, Bulkhead{})
+
+	partition := client.bulkheads.partition("reporting")
+	partition.acquire(context.Background())
+	time.AfterFunc(10*time.Millisecond, partition.release)
+
+	err := client.DoContext(WithBulkhead(context.Background(), "reporting"), noopWork)
+
+	assert.Nil(t, err)
+	mockStats.AssertCalled(t,
+		"Timing",
+		"myapp.bulkheadwait", append(client.config.StatsD.Tags, "bulkhead:reporting"), mock.Anything, mock.Anything)
+}
+
+func TestBulkheadStopsWaitingWhenContextIsDone(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{
+		"reporting": {MaxConcurrentRequests: 1, QueueTimeout: 1 * time.Second},
+	}, Bulkhead{})
+	client.bulkheads.partition("reporting").acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	startTime := time.Now()
+	err := client.DoContext(WithBulkhead(ctx, "reporting"), noopWork)
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Now().Sub(startTime) < 500*time.Millisecond)
+}
+
+func TestDefaultBulkheadIsKeptPerPartition(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(nil, Bulkhead{MaxConcurrentRequests: 1})
+	client.bulkheads.partition("tenant-a").acquire(context.Background())
+
+	errA := client.DoContext(WithBulkhead(context.Background(), "tenant-a"), noopWork)
+	errB := client.DoContext(WithBulkhead(context.Background(), "tenant-b"), noopWork)
+
+	assert.Equal(t, ErrorBulkheadFull, errA.(ClientError).Message)
+	assert.Nil(t, errB)
+}
+
+func TestDefaultBulkheadIsRemovedWhenUnused(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(nil, Bulkhead{MaxConcurrentRequests: 1})
+	client.bulkheads.partition("tenant-a").acquire(context.Background())
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("tenant-%v", i)
+		assert.Nil(t, client.DoContext(WithBulkhead(context.Background(), name), noopWork))
+	}
+	client.DoContext(WithBulkhead(context.Background(), "tenant-a"), noopWork)
+
+	assert.Len(t, client.bulkheads.defaulted, 1)
+	assert.NotNil(t, client.bulkheads.defaulted["tenant-a"])
+}
+
+func TestUnlimitedBulkheadsAreNotKept(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 1}}, Bulkhead{})
+
+	assert.Nil(t, client.DoContext(WithBulkhead(context.Background(), "tenant-a"), noopWork))
+
+	assert.Len(t, client.bulkheads.partitions, 1)
+	assert.Len(t, client.bulkheads.defaulted, 0)
+}
+
+func TestBulkheadReleasesSlotWhenWorkCompletes(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 1}}, Bulkhead{})
+
+	ctx := WithBulkhead(context.Background(), "reporting")
+
+	assert.Nil(t, client.DoContext(ctx, noopWork))
+	assert.Nil(t, client.DoContext(ctx, noopWork))
+}
+
+func TestBulkheadTakesOneSlotForEveryAttempt(t *testing.T) {
+	setupClient(2)
+	setupBulkheads(map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 1}}, Bulkhead{})
+
+	callCount := 0
+	err := client.DoContext(WithBulkhead(context.Background(), "reporting"), func(ctx context.Context, endpoint url.URL) error {
+		callCount++
+		return fmt.Errorf("boom")
+	})
+
+	assert.NotEqual(t, ErrorBulkheadFull, err.(ClientError).Message)
+	assert.Equal(t, 3, callCount)
+
+	waits := 0
+	for _, call := range mockStats.Calls {
+		if call.Method == "Timing" && call.Arguments.Get(0) == "myapp.bulkheadwait" {
+			waits++
+		}
+	}
+	assert.Equal(t, 1, waits)
+}
+
+func TestBulkheadTakesOneSlotForDoAll(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 1}}, Bulkhead{})
+
+	results, err := client.DoAll(WithBulkhead(context.Background(), "reporting"), noopWork)
+
+	assert.Nil(t, err)
+	assert.Len(t, results, len(urls))
+}
+
+func TestBulkheadKeepsSlotUntilTimedOutWorkReturns(t *testing.T) {
+	setupClient(0)
+	setupBulkheads(map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 1}}, Bulkhead{})
+
+	ctx := WithBulkhead(context.Background(), "reporting")
+	done := make(chan struct{})
+	err := client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		<-done
+		return nil
+	})
+
+	assert.Equal(t, ErrorTimeout, err.(ClientError).Message)
+
+	err = client.DoContext(ctx, noopWork)
+	assert.Equal(t, ErrorBulkheadFull, err.(ClientError).Message)
+
+	close(done)
+	for i := 0; i < 100 && err != nil; i++ {
+		time.Sleep(time.Millisecond)
+		err = client.DoContext(ctx, noopWork)
+	}
+
+	assert.Nil(t, err)
+}
@@ -0,0 +1,130 @@
+package ultraclient
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DoCached performs the work in the same way as DoShared and caches the
+// returned value under the key, the keys are separate from those of DoShared.
+// While the value is within Config.Cache.TTL it is returned without performing
+// the work, when the work fails with an error which can be retried or because
+// the circuit is open the cached value is returned for a further
+// Config.Cache.MaxStale.  Errors wrapped with BadRequest or NonRetryable are
+// always returned to the caller.  When the cache is not configured the work is
+// always performed.
+// value, err := client.DoCached(ctx, "user:"+id, func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+//   return fetchUser(ctx, endpoint, id)
+// })
+func (c *ClientImpl) DoCached(ctx context.Context, key string, work SharedWorkFunc) (interface{}, error) {
+	if c.cache == nil {
+		return c.doShared(ctx, c.cachedCalls, key, work)
+	}
+
+	cached, fresh, stale := c.cache.get(key)
+	if fresh {
+		c.incrementStats(nil, StatsCacheHit)
+		return cached, nil
+	}
+
+	c.incrementStats(nil, StatsCacheMiss)
+
+	value, err := c.doShared(ctx, c.cachedCalls, key, work)
+	if err == nil {
+		c.cache.set(key, value)
+		return value, nil
+	}
+
+	if stale && ctx.Err() == nil && canServeStale(err) {
+		c.incrementStats(nil, StatsCacheStale)
+		return cached, nil
+	}
+
+	return nil, err
+}
+
+// canServeStale returns true when a stale value can be returned in place of
+// the error, only failures of the endpoints are hidden from the caller
+func canServeStale(err error) bool {
+	return isRetryable(err) || isCircuitOpen(err)
+}
+
+// responseCache is an in memory LRU cache of the values returned from work,
+// it is shared between a client and its clones
+type responseCache struct {
+	sync.Mutex
+	size     int
+	ttl      time.Duration
+	maxStale time.Duration
+	entries  map[string]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+type cacheEntry struct {
+	key    string
+	value  interface{}
+	stored time.Time
+}
+
+func newResponseCache(config Cache) *responseCache {
+	return &responseCache{
+		size:     config.Size,
+		ttl:      config.TTL,
+		maxStale: config.MaxStale,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// get returns the value for the key, fresh is true when the value is within
+// the ttl and stale is true when the value can only be used when the work fails
+func (r *responseCache) get(key string) (value interface{}, fresh, stale bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	element, ok := r.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	age := r.now().Sub(entry.stored)
+
+	switch {
+	case age < r.ttl:
+		r.order.MoveToFront(element)
+		return entry.value, true, false
+	case age < r.ttl+r.maxStale:
+		return entry.value, false, true
+	default:
+		r.order.Remove(element)
+		delete(r.entries, key)
+		return nil, false, false
+	}
+}
+
+func (r *responseCache) set(key string, value interface{}) {
+	r.Lock()
+	defer r.Unlock()
+
+	if element, ok := r.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.value = value
+		entry.stored = r.now()
+		r.order.MoveToFront(element)
+
+		return
+	}
+
+	r.entries[key] = r.order.PushFront(&cacheEntry{key: key, value: value, stored: r.now()})
+
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,222 @@
+package ultraclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var cacheNow time.Time
+
+func setupCache() {
+	setupClient(0)
+
+	cacheNow = time.Now()
+	client.cache = newResponseCache(Cache{Size: 2, TTL: 1 * time.Minute, MaxStale: 5 * time.Minute})
+	client.cache.now = func() time.Time { return cacheNow }
+}
+
+func cachedWork(callCount *int, err error) SharedWorkFunc {
+	return func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		*callCount++
+		if err != nil {
+			return nil, err
+		}
+
+		return fmt.Sprintf("value%v", *callCount), nil
+	}
+}
+
+func TestDoCachedReturnsFreshValueWithoutPerformingWork(t *testing.T) {
+	setupCache()
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	value, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", value)
+	assert.Equal(t, 1, callCount)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.cachehit", client.config.StatsD.Tags, mock.Anything)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.cachemiss", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestDoCachedPerformsWorkWhenValueExpires(t *testing.T) {
+	setupCache()
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	cacheNow = cacheNow.Add(2 * time.Minute)
+	value, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", value)
+	assert.Equal(t, 2, callCount)
+}
+
+func TestDoCachedReturnsStaleValueWhenWorkFails(t *testing.T) {
+	setupCache()
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	cacheNow = cacheNow.Add(2 * time.Minute)
+	value, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, fmt.Errorf("aaah")))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", value)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.cachestale", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestDoCachedReturnsErrorWhenValueIsTooStale(t *testing.T) {
+	setupCache()
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	cacheNow = cacheNow.Add(10 * time.Minute)
+	_, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, fmt.Errorf("aaah")))
+
+	assert.NotNil(t, err)
+}
+
+func TestDoCachedDoesNotReturnStaleValueForBadRequests(t *testing.T) {
+	setupCache()
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	cacheNow = cacheNow.Add(2 * time.Minute)
+	_, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, BadRequest(fmt.Errorf("not found"))))
+
+	assert.NotNil(t, err)
+}
+
+func TestDoCachedDoesNotReturnStaleValueForNonRetryableErrors(t *testing.T) {
+	setupCache()
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	cacheNow = cacheNow.Add(2 * time.Minute)
+	_, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, NonRetryable(fmt.Errorf("invalid"))))
+
+	assert.NotNil(t, err)
+	mockStats.AssertNotCalled(t, "Increment", "myapp.cachestale", mock.Anything, mock.Anything)
+}
+
+func TestCanServeStale(t *testing.T) {
+	tests := map[error]bool{
+		fmt.Errorf("boom"):                                                  true,
+		ClientError{Message: ErrorTimeout}:                                  true,
+		NonRetryable(ClientError{Message: ErrorCircuitOpen}):                true,
+		NonRetryable(fmt.Errorf("invalid")):                                 false,
+		BadRequest(fmt.Errorf("not found")):                                 false,
+		ClientError{Message: ErrorLoadShed, Err: NonRetryable(errLoadShed)}: false,
+	}
+
+	for err, expected := range tests {
+		assert.Equal(t, expected, canServeStale(err), err.Error())
+	}
+}
+
+func TestDoCachedDoesNotShareWorkWithDoShared(t *testing.T) {
+	setupSharedClient()
+	client.cache = newResponseCache(Cache{Size: 2, TTL: 1 * time.Minute})
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		client.DoShared(context.Background(), "key", func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+			<-release
+			return "shared", nil
+		})
+		close(done)
+	}()
+	waitForWaiters("key", 1)
+
+	callCount := 0
+	value, err := client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+
+	close(release)
+	<-done
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", value)
+	assert.Equal(t, 1, callCount)
+}
+
+func TestDoCachedAlwaysPerformsWorkWhenCacheDisabled(t *testing.T) {
+	setupClient(0)
+	callCount := 0
+
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+	client.DoCached(context.Background(), "key", cachedWork(&callCount, nil))
+
+	assert.Equal(t, 2, callCount)
+}
+
+func TestCacheEvictsLeastRecentlyUsedValue(t *testing.T) {
+	cache := newResponseCache(Cache{Size: 2, TTL: 1 * time.Minute})
+
+	cache.set("a", 1)
+	cache.set("b", 2)
+	cache.get("a")
+	cache.set("c", 3)
+
+	_, aFresh, _ := cache.get("a")
+	_, bFresh, _ := cache.get("b")
+	_, cFresh, _ := cache.get("c")
+
+	assert.True(t, aFresh)
+	assert.False(t, bFresh)
+	assert.True(t, cFresh)
+}
+
+func TestTypedDoCachedReturnsValue(t *testing.T) {
+	setupCache()
+
+	DoCached(context.Background(), client, "key", func(ctx context.Context, endpoint url.URL) (int, error) {
+		return 42, nil
+	})
+	value, err := DoCached(context.Background(), client, "key", func(ctx context.Context, endpoint url.URL) (int, error) {
+		return 0, fmt.Errorf("should not be called")
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 42, value)
+}
+
+func TestTypedDoCachedReturnsErrorWhenKeyHoldsAnotherType(t *testing.T) {
+	setupCache()
+
+	DoCached(context.Background(), client, "key", func(ctx context.Context, endpoint url.URL) (string, error) {
+		return "42", nil
+	})
+	value, err := DoCached(context.Background(), client, "key", func(ctx context.Context, endpoint url.URL) (int, error) {
+		return 42, nil
+	})
+
+	assert.Equal(t, TypeMismatchError{Key: "key", Value: "42", Type: reflect.TypeOf(0)}, err)
+	assert.Equal(t, "value for key key is a string not a int", err.Error())
+	assert.Equal(t, 0, value)
+}
+
+func TestTypedDoCachedReturnsNilPointer(t *testing.T) {
+	setupCache()
+
+	value, err := DoCached(context.Background(), client, "key", func(ctx context.Context, endpoint url.URL) (*string, error) {
+		return nil, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+}
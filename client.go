@@ -1,71 +1,281 @@
 package ultraclient
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/afex/hystrix-go/hystrix"
-	"github.com/eapache/go-resiliency/retrier"
 )
 
 // WorkFunc defines the work function to be passed to the Client.Do method
 type WorkFunc func(endpoint url.URL) error
 
+// ContextWorkFunc defines the work function to be passed to the
+// Client.DoContext method
+type ContextWorkFunc func(ctx context.Context, endpoint url.URL) error
+
+// FallbackFunc defines the fallback function to be passed to the
+// Client.DoWithFallback method, err is the error returned from the client
+type FallbackFunc func(err error) error
+
+// EndpointResult is the result of performing work against a single endpoint
+type EndpointResult struct {
+	// Endpoint is the endpoint the work was performed against
+	Endpoint url.URL
+
+	// Err is the error returned from the work, nil when the work succeeded
+	Err error
+}
+
 // Config defines the configuration for the Client
 type Config struct {
 	// Timeout is the length of time to wait before the work function times out
-	Timeout time.Duration
+	Timeout time.Duration `json:"timeout" yaml:"timeout" env:"TIMEOUT"`
 
 	// MaxConcurrentRequests is the maximum number of work requests which can be
 	// active at anyone time.
-	MaxConcurrentRequests int
+	MaxConcurrentRequests int `json:"max_concurrent_requests" yaml:"max_concurrent_requests" env:"MAX_CONCURRENT_REQUESTS"`
 
 	// ErrorPercentThreshold is the percentage of work requests which result in
 	// error before the circuit opens.
-	ErrorPercentThreshold int
+	ErrorPercentThreshold int `json:"error_percent_threshold" yaml:"error_percent_threshold" env:"ERROR_PERCENT_THRESHOLD"`
 
 	//
-	DefaultVolumeThreshold int
+	DefaultVolumeThreshold int `json:"default_volume_threshold" yaml:"default_volume_threshold" env:"DEFAULT_VOLUME_THRESHOLD"`
 
 	// Retries is the number of times a request should be attempted before
 	// returning.
-	Retries int
+	Retries int `json:"retries" yaml:"retries" env:"RETRIES"`
 
 	// RetryDelay is the default amount of time to wait before retrying the
 	// work.
-	RetryDelay time.Duration
+	RetryDelay time.Duration `json:"retry_delay" yaml:"retry_delay" env:"RETRY_DELAY"`
+
+	// MaxRetryAfter caps the delay requested by a WorkFunc which returns a
+	// RetryAfter error, when zero DefaultMaxRetryAfter is used.
+	MaxRetryAfter time.Duration `json:"max_retry_after" yaml:"max_retry_after" env:"MAX_RETRY_AFTER"`
+
+	// CancelAbandonedSharedWork cancels work started by DoShared once every
+	// caller waiting on it has given up, by default the work runs until it
+	// completes.
+	CancelAbandonedSharedWork bool `json:"cancel_abandoned_shared_work" yaml:"cancel_abandoned_shared_work" env:"CANCEL_ABANDONED_SHARED_WORK"`
 
 	// Endpoints which are passed to the loadbalancing strategy and then to the
 	// work function.
-	Endpoints []url.URL
+	Endpoints []url.URL `json:"endpoints" yaml:"endpoints" env:"ENDPOINTS"`
 
 	// Enable statsd metrixs for client
-	StatsD StatsD
+	StatsD StatsD `json:"statsd" yaml:"statsd" env:"STATSD_"`
+
+	// Cache configures the response cache used by DoCached
+	Cache Cache `json:"cache" yaml:"cache" env:"CACHE_"`
+
+	// RateLimit limits the rate of requests made by the client, the limit is
+	// shared with the clones of the client
+	RateLimit RateLimit `json:"rate_limit" yaml:"rate_limit" env:"RATE_LIMIT_"`
+
+	// EndpointRateLimit limits the rate of requests made to each endpoint
+	EndpointRateLimit RateLimit `json:"endpoint_rate_limit" yaml:"endpoint_rate_limit" env:"ENDPOINT_RATE_LIMIT_"`
+
+	// AdaptiveConcurrency limits the number of requests in flight with a
+	// limit which adjusts to the latency and errors of the endpoints
+	AdaptiveConcurrency AdaptiveConcurrency `json:"adaptive_concurrency" yaml:"adaptive_concurrency" env:"ADAPTIVE_CONCURRENCY_"`
+
+	// LoadShedding rejects work by priority as the client approaches its
+	// concurrency and error limits
+	LoadShedding LoadShedding `json:"load_shedding" yaml:"load_shedding" env:"LOAD_SHEDDING_"`
+
+	// Bulkheads limits the work in flight for each named partition, work is
+	// given a partition with WithBulkhead
+	Bulkheads map[string]Bulkhead `json:"bulkheads" yaml:"bulkheads"`
+
+	// DefaultBulkhead is used for partitions which are not in Bulkheads, each
+	// partition has its own bulkhead with these limits
+	DefaultBulkhead Bulkhead `json:"default_bulkhead" yaml:"default_bulkhead" env:"DEFAULT_BULKHEAD_"`
 }
 
 // StatsD is the configuration for the StatsD endpoint
 type StatsD struct {
-	Prefix string
-	Tags   []string
+	Prefix string   `json:"prefix" yaml:"prefix" env:"PREFIX"`
+	Tags   []string `json:"tags" yaml:"tags" env:"TAGS"`
+}
+
+// RateLimit is the configuration for a token bucket rate limiter
+type RateLimit struct {
+	// Rate is the number of requests allowed per second, the limit is disabled
+	// when zero
+	Rate float64 `json:"rate" yaml:"rate" env:"RATE"`
+
+	// Burst is the number of requests which can be made at once, when zero
+	// a burst of 1 is used
+	Burst int `json:"burst" yaml:"burst" env:"BURST"`
+
+	// Wait makes requests over the limit wait until they are allowed or the
+	// context is done, by default requests over the limit are rejected
+	Wait bool `json:"wait" yaml:"wait" env:"WAIT"`
+}
+
+// AdaptiveConcurrency is the configuration for the adaptive concurrency
+// limit, the limit is increased by one while requests succeed and the limit is
+// being used and is multiplied by the BackoffRatio when a request fails, times
+// out or is slower than the LatencyThreshold
+type AdaptiveConcurrency struct {
+	// InitialLimit is the number of requests allowed in flight when the client
+	// is created, the limit is disabled when zero
+	InitialLimit int `json:"initial_limit" yaml:"initial_limit" env:"INITIAL_LIMIT"`
+
+	// MinLimit is the lowest the limit can fall to, when zero 1 is used
+	MinLimit int `json:"min_limit" yaml:"min_limit" env:"MIN_LIMIT"`
+
+	// MaxLimit is the highest the limit can rise to, when zero 1000 is used
+	MaxLimit int `json:"max_limit" yaml:"max_limit" env:"MAX_LIMIT"`
+
+	// BackoffRatio is the ratio the limit is multiplied by when a request
+	// fails, when zero 0.9 is used
+	BackoffRatio float64 `json:"backoff_ratio" yaml:"backoff_ratio" env:"BACKOFF_RATIO"`
+
+	// LatencyThreshold is the duration above which a successful request is
+	// treated as a failure, when zero only errors and timeouts reduce the limit
+	LatencyThreshold time.Duration `json:"latency_threshold" yaml:"latency_threshold" env:"LATENCY_THRESHOLD"`
+
+	// PerEndpoint keeps a separate limit for each endpoint, by default a
+	// single limit is shared by the client and its clones
+	PerEndpoint bool `json:"per_endpoint" yaml:"per_endpoint" env:"PER_ENDPOINT"`
+}
+
+// LoadShedding is the configuration for the load shedding policy.  The load
+// on the client is the greater of the requests in flight as a proportion of
+// MaxConcurrentRequests and the error percentage over the last ten seconds as
+// a proportion of ErrorPercentThreshold, work is rejected when the load
+// reaches the threshold for its priority.  PriorityCritical work is never
+// rejected.
+type LoadShedding struct {
+	// Enabled turns on load shedding
+	Enabled bool `json:"enabled" yaml:"enabled" env:"ENABLED"`
+
+	// LowThreshold is the load at which PriorityLow work is rejected, when
+	// zero 0.5 is used
+	LowThreshold float64 `json:"low_threshold" yaml:"low_threshold" env:"LOW_THRESHOLD"`
+
+	// NormalThreshold is the load at which PriorityNormal work is rejected,
+	// when zero 0.8 is used
+	NormalThreshold float64 `json:"normal_threshold" yaml:"normal_threshold" env:"NORMAL_THRESHOLD"`
+
+	// HighThreshold is the load at which PriorityHigh work is rejected, when
+	// zero 0.95 is used
+	HighThreshold float64 `json:"high_threshold" yaml:"high_threshold" env:"HIGH_THRESHOLD"`
+}
+
+// Bulkhead is the configuration for a bulkhead partition
+type Bulkhead struct {
+	// MaxConcurrentRequests is the number of requests the partition can have
+	// in flight, the partition is not limited when zero
+	MaxConcurrentRequests int `json:"max_concurrent_requests" yaml:"max_concurrent_requests" env:"MAX_CONCURRENT_REQUESTS"`
+
+	// QueueTimeout is the length of time a request waits for a free slot
+	// before it is rejected, by default requests are rejected immediately
+	QueueTimeout time.Duration `json:"queue_timeout" yaml:"queue_timeout" env:"QUEUE_TIMEOUT"`
+}
+
+// Cache is the configuration for the response cache
+type Cache struct {
+	// Size is the maximum number of values held in the cache, the least
+	// recently used value is evicted when full.  The cache is disabled when
+	// zero.
+	Size int `json:"size" yaml:"size" env:"SIZE"`
+
+	// TTL is the length of time a cached value is returned without performing
+	// the work
+	TTL time.Duration `json:"ttl" yaml:"ttl" env:"TTL"`
+
+	// MaxStale is the length of time after the TTL that a cached value is
+	// returned when the work fails
+	MaxStale time.Duration `json:"max_stale" yaml:"max_stale" env:"MAX_STALE"`
 }
 
 //Client is an interface that defines the behaviour of an ultraclient
 type Client interface {
 	Do(work WorkFunc) error
+	DoContext(ctx context.Context, work ContextWorkFunc) error
+	DoWithFallback(work WorkFunc, fallback FallbackFunc) error
+	DoContextWithFallback(ctx context.Context, work ContextWorkFunc, fallback FallbackFunc) error
+	DoAsync(work WorkFunc) <-chan error
+	DoAll(ctx context.Context, work ContextWorkFunc) ([]EndpointResult, error)
+	DoQuorum(ctx context.Context, n int, work ContextWorkFunc) ([]EndpointResult, error)
+	DoShared(ctx context.Context, key string, work SharedWorkFunc) (interface{}, error)
+	DoCached(ctx context.Context, key string, work SharedWorkFunc) (interface{}, error)
 	UpdateEndpoints([]url.URL)
+	Endpoints() []url.URL
+	Discover(ctx context.Context, discoverer Discoverer, debounce time.Duration)
+	Reload(config Config) error
 	RegisterStats(stats Stats)
+	RegisterTracer(tracer Tracer)
 	Clone() Client
 }
 
 // ClientImpl is a loadbalancing client with configurable backoff and loadbalancing strategy,
 // Client also implements circuit breaking for fail fast.
 type ClientImpl struct {
-	config                Config
 	loadbalancingStrategy LoadbalancingStrategy
-	backoffStrategy       BackoffStrategy
-	retry                 *retrier.Retrier
 	statsCollection       []Stats
+	tracer                Tracer
+
+	// clientSettings holds the settings which can be changed by Reload,
+	// shared with clones
+	*clientSettings
+
+	// lbLock guards the loadbalancing strategy which is not safe for
+	// concurrent use by asynchronous work
+	lbLock sync.Mutex
+
+	// syncedVersion is the version of the reloaded endpoints given to the
+	// loadbalancing strategy, guarded by lbLock
+	syncedVersion int
+
+	// inFlight is the number of requests in flight for the client and its
+	// clones
+	inFlight *atomic.Int64
+
+	// openCircuits are the endpoints last seen with an open circuit, shared
+	// with clones
+	openCircuits *openCircuits
+
+	// asyncSlots bounds the asynchronous work in flight for the client and its
+	// clones, nil when unbounded
+	asyncSlots chan struct{}
+
+	// sharedCalls is the work in flight for DoShared, shared with clones
+	sharedCalls *sharedCalls
+
+	// cachedCalls is the work in flight for DoCached, kept apart from
+	// sharedCalls so the keys of the two methods do not collide, shared with
+	// clones
+	cachedCalls *sharedCalls
+
+	// cache holds the values for DoCached, shared with clones, nil when
+	// disabled
+	cache *responseCache
+
+	// rateLimiters limits the rate of requests, shared with clones, nil when
+	// disabled
+	rateLimiters *rateLimiters
+
+	// concurrencyLimiters limits the requests in flight, shared with clones,
+	// nil when disabled
+	concurrencyLimiters *concurrencyLimiters
+
+	// loadShedder rejects work by priority under load, shared with clones,
+	// nil when disabled
+	loadShedder *loadShedder
+
+	// bulkheads limits the work in flight for each partition, shared with
+	// clones, nil when disabled
+	bulkheads *bulkheads
 }
 
 // Do perfoms the work for the client, the WorkFunc passed as a parameter
@@ -81,122 +291,706 @@ type ClientImpl struct {
 //   response = resp // set the outer variable
 //   return nil
 // }
+// If the WorkFunc returns an error wrapped with RetryAfter the client waits
+// for the given delay before the next attempt instead of the backoff, an error
+// wrapped with NonRetryable or BadRequest is returned without any further
+// attempts.
 func (c *ClientImpl) Do(work WorkFunc) error {
-	err := c.retry.Run(func() error {
-		return c.doRequest(work)
+	return c.DoContext(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		return work(endpoint)
 	})
+}
+
+// DoContext performs the work for the client in the same way as Do, the
+// context is passed to the work function and no further attempts are made
+// once the context is done.
+func (c *ClientImpl) DoContext(ctx context.Context, work ContextWorkFunc) (err error) {
+	ctx, span := c.startSpan(ctx, SpanDo)
 
+	attempts := 0
+	defer func() { endSpan(span, err, Attribute{Key: AttributeAttempts, Value: attempts}) }()
+
+	ctx, leave, err := c.enterBulkhead(ctx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	err = c.settings().retry.run(ctx, func(attempt int, backoff time.Duration) error {
+		attempts = attempt
+		return c.doRequest(ctx, attempt, backoff, work)
+	})
+
+	c.attemptStats(attempts)
 	return err
 }
 
-// UpdateEndpoints makes the given endpoints  available to the loadbalancer
+// DoWithFallback performs the work in the same way as Do, when the work can
+// not be completed the fallback is called once with the error.  The fallback
+// is called as soon as the circuit is open for every endpoint rather than
+// waiting for the retries to be exhausted.  Errors wrapped with BadRequest are
+// returned without calling the fallback.
+// err := client.DoWithFallback(work, func(err error) error {
+//   response = cachedResponse
+//   return nil
+// })
+func (c *ClientImpl) DoWithFallback(work WorkFunc, fallback FallbackFunc) error {
+	return c.DoContextWithFallback(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		return work(endpoint)
+	}, fallback)
+}
+
+// DoContextWithFallback performs the work in the same way as DoWithFallback,
+// the context is passed to the work function.
+func (c *ClientImpl) DoContextWithFallback(ctx context.Context, work ContextWorkFunc, fallback FallbackFunc) (err error) {
+	ctx, span := c.startSpan(ctx, SpanDo)
+
+	attempts := 0
+	usedFallback := false
+	defer func() {
+		endSpan(span, err,
+			Attribute{Key: AttributeAttempts, Value: attempts},
+			Attribute{Key: AttributeFallback, Value: usedFallback})
+	}()
+
+	var circuitErr error
+	ctx, leave, err := c.enterBulkhead(ctx)
+	if err == nil {
+		err = c.settings().retry.run(ctx, func(attempt int, backoff time.Duration) error {
+			attempts = attempt
+			err := c.doRequest(ctx, attempt, backoff, work)
+			if isCircuitOpen(err) && c.allCircuitsOpen() {
+				circuitErr = err
+				return NonRetryable(err)
+			}
+
+			return err
+		})
+
+		leave()
+		c.attemptStats(attempts)
+	}
+
+	if circuitErr != nil {
+		err = circuitErr
+	}
+
+	if err == nil || isBadRequest(err) {
+		return err
+	}
+
+	usedFallback = true
+	if ferr := fallback(err); ferr != nil {
+		c.incrementStats(nil, StatsFallbackError)
+		return ferr
+	}
+
+	c.incrementStats(nil, StatsFallbackSuccess)
+	return nil
+}
+
+// DoAsync performs the work in the same way as Do in a new goroutine, the
+// returned channel receives the result of the work and is then closed.  The
+// number of calls in flight is bounded by Config.MaxConcurrentRequests, when
+// the limit is reached the channel receives an error immediately.
+// users := client.DoAsync(fetchUsers)
+// orders := client.DoAsync(fetchOrders)
+// if err := <-users; err != nil {
+//   ...
+// }
+func (c *ClientImpl) DoAsync(work WorkFunc) <-chan error {
+	errChan := make(chan error, 1)
+
+	if !c.acquireAsyncSlot() {
+		c.incrementStats(nil, StatsMaxConcurrency)
+		errChan <- ClientError{Message: ErrorMaxConcurrency}
+		close(errChan)
+
+		return errChan
+	}
+
+	go func() {
+		defer c.releaseAsyncSlot()
+
+		errChan <- c.Do(work)
+		close(errChan)
+	}()
+
+	return errChan
+}
+
+// DoAll performs the work against every endpoint concurrently rather than
+// the endpoint chosen by the loadbalancer, failed work is retried against the
+// same endpoint.  The results for each endpoint are returned in the order they
+// complete, an error is returned when the work fails for any endpoint.
+// results, err := client.DoAll(ctx, func(ctx context.Context, endpoint url.URL) error {
+//   return invalidateCache(ctx, endpoint, key)
+// })
+func (c *ClientImpl) DoAll(ctx context.Context, work ContextWorkFunc) (results []EndpointResult, err error) {
+	ctx, span := c.startSpan(ctx, SpanDoAll)
+	defer func() { endSpan(span, err) }()
+
+	ctx, leave, err := c.enterBulkhead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	endpoints := c.endpoints()
+	resultChan := c.scatter(ctx, endpoints, work)
+
+	results = make([]EndpointResult, 0, len(endpoints))
+	for range endpoints {
+		result := <-resultChan
+		results = append(results, result)
+
+		if result.Err != nil {
+			err = ClientError{Message: ErrorQuorumNotReached}
+		}
+	}
+
+	return results, err
+}
+
+// DoQuorum performs the work against every endpoint concurrently in the same
+// way as DoAll, it returns as soon as the work has succeeded for n endpoints or
+// when too many endpoints have failed for n to be reached.  Work still in
+// flight is cancelled and the results are returned for the endpoints which
+// have completed.
+func (c *ClientImpl) DoQuorum(ctx context.Context, n int, work ContextWorkFunc) (results []EndpointResult, err error) {
+	ctx, span := c.startSpan(ctx, SpanDoQuorum)
+	defer func() { endSpan(span, err) }()
+
+	endpoints := c.endpoints()
+	if n < 1 || n > len(endpoints) {
+		return nil, fmt.Errorf("quorum of %v is not possible with %v endpoints", n, len(endpoints))
+	}
+
+	ctx, leave, err := c.enterBulkhead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resultChan := c.scatter(ctx, endpoints, work)
+
+	succeeded := 0
+	failed := 0
+	results = make([]EndpointResult, 0, len(endpoints))
+	for range endpoints {
+		result := <-resultChan
+		results = append(results, result)
+
+		if result.Err == nil {
+			succeeded++
+		} else {
+			failed++
+		}
+
+		if succeeded >= n {
+			return results, nil
+		}
+
+		if failed > len(endpoints)-n {
+			break
+		}
+	}
+
+	return results, ClientError{Message: ErrorQuorumNotReached}
+}
+
+// UpdateEndpoints makes the given endpoints available to the loadbalancer of
+// the client and its clones, each endpoint is given a weight of 1
 func (c *ClientImpl) UpdateEndpoints(endpoints []url.URL) {
-	c.loadbalancingStrategy.SetEndpoints(endpoints)
+	c.updateEndpoints(endpoints, defaultMetadata(endpoints))
+}
+
+// updateEndpoints makes the endpoints available to the loadbalancer, the
+// metadata is passed to strategies which implement
+// WeightedLoadbalancingStrategy
+func (c *ClientImpl) updateEndpoints(endpoints []url.URL, metadata []EndpointMetadata) {
+	configureCommands(endpoints, c.settings().config)
+
+	c.updateSettings(func(settings *clientSettings) {
+		settings.config.Endpoints = endpoints
+		settings.metadata = metadata
+		settings.endpointsVersion++
+	})
+
+	c.publishEndpointGauges()
+
+	c.lbLock.Lock()
+	defer c.lbLock.Unlock()
+
+	c.syncEndpoints()
+}
+
+// Endpoints returns the endpoints currently available to the loadbalancer of
+// the client and its clones
+func (c *ClientImpl) Endpoints() []url.URL {
+	return c.settings().config.Endpoints
 }
 
 // RegisterStats registers a stats interface with the client, multiple interfaces can
 // be registered with a single client
 func (c *ClientImpl) RegisterStats(stats Stats) {
 	c.statsCollection = append(c.statsCollection, stats)
+	c.publishEndpointGauges()
+	c.publishConcurrencyLimit()
 }
 
 // Clone creates a clone of the client and should be used to ensure that
 // the loadbalancing is local to the current GoRoutine
 func (c *ClientImpl) Clone() Client {
+	c.lbLock.Lock()
+	defer c.lbLock.Unlock()
+
 	return &ClientImpl{
-		config:                c.config,
 		loadbalancingStrategy: c.loadbalancingStrategy.Clone(),
-		backoffStrategy:       c.backoffStrategy,
 		statsCollection:       c.statsCollection,
-		retry:                 c.retry,
+		tracer:                c.tracer,
+		clientSettings:        c.clientSettings,
+		syncedVersion:         c.syncedVersion,
+		inFlight:              c.inFlight,
+		openCircuits:          c.openCircuits,
+		asyncSlots:            c.asyncSlots,
+		sharedCalls:           c.sharedCalls,
+		cachedCalls:           c.cachedCalls,
+		cache:                 c.cache,
+		rateLimiters:          c.rateLimiters,
+		concurrencyLimiters:   c.concurrencyLimiters,
+		loadShedder:           c.loadShedder,
+		bulkheads:             c.bulkheads,
 	}
 }
 
-func (c *ClientImpl) doRequest(work WorkFunc) error {
-	endpoint := c.loadbalancingStrategy.NextEndpoint()
+func (c *ClientImpl) doRequest(ctx context.Context, attempt int, backoff time.Duration, work ContextWorkFunc) error {
+	return c.doEndpoint(ctx, c.nextEndpoint(), attempt, backoff, work)
+}
+
+func (c *ClientImpl) doEndpoint(
+	ctx context.Context,
+	endpoint url.URL,
+	attempt int,
+	backoff time.Duration,
+	work ContextWorkFunc) (err error) {
+
+	ctx, span := c.startAttempt(ctx, &endpoint, attempt, backoff)
+	defer func() { span.finish(err) }()
+
+	hold := bulkheadSlotFromContext(ctx).hold()
+	defer hold.finish()
+
+	release, err := c.admit(ctx, &endpoint)
+	if err != nil {
+		return err
+	}
 
 	c.incrementStats(&endpoint, StatsCalled)
+	c.gaugeStats(nil, float64(c.inFlight.Add(1)), StatsInFlight)
 
 	startTime := time.Now()
 	defer func() {
 		c.timingStats(&endpoint, time.Now().Sub(startTime), StatsTiming)
 	}()
 
-	err := hystrix.Do(endpoint.String(), func() error {
-		return work(endpoint)
+	// a bad request is not a failure of the endpoint so it is hidden from the
+	// circuit breaker and returned once the command completes
+	badRequest := make(chan error, 1)
+	err = hystrix.Do(endpoint.String(), func() error {
+		// hystrix has already timed out the attempt
+		if !hold.workStarted() {
+			return nil
+		}
+		defer hold.workDone()
+
+		span.workStarted()
+		defer span.workDone()
+
+		err := work(ctx, endpoint)
+
+		// the caller giving up is not a failure of the endpoint
+		if err != nil && ctx.Err() != nil {
+			err = BadRequest(err)
+		}
+
+		if isBadRequest(err) {
+			badRequest <- err
+			return nil
+		}
+
+		return err
 	}, nil)
 
+	c.circuitStats(&endpoint, err)
+
+	if err == nil {
+		select {
+		case err = <-badRequest:
+		default:
+		}
+	}
+
+	release(err, time.Now().Sub(startTime))
+
+	c.gaugeStats(nil, float64(c.inFlight.Add(-1)), StatsInFlight)
+
 	return c.handleError(&endpoint, err)
 }
 
+// admit applies the load shedding, rate and concurrency limits before work
+// is performed against the endpoint, the returned function must be called
+// with the outcome of admitted work.  The bulkhead is applied once for the
+// whole call by enterBulkhead.
+func (c *ClientImpl) admit(ctx context.Context, endpoint *url.URL) (func(err error, duration time.Duration), error) {
+	if err := c.shed(ctx, endpoint); err != nil {
+		return nil, err
+	}
+
+	if err := c.rateLimit(ctx, endpoint); err != nil {
+		c.shedDone(outcomeIgnored)
+		return nil, err
+	}
+
+	limiter, err := c.acquireConcurrency(endpoint)
+	if err != nil {
+		c.shedDone(outcomeIgnored)
+		return nil, err
+	}
+
+	return func(err error, duration time.Duration) {
+		c.releaseConcurrency(ctx, limiter, endpoint, err, duration)
+		c.shedDone(outcome(ctx, err, 0, 0))
+	}, nil
+}
+
 func (c *ClientImpl) handleError(endpoint *url.URL, err error) error {
 	switch err {
 	case hystrix.ErrTimeout:
 		c.incrementStats(endpoint, StatsTimeout)
-		return ClientError{ErrorTimeout, *endpoint}
+		return ClientError{Message: ErrorTimeout, URL: *endpoint}
 	case hystrix.ErrCircuitOpen:
 		c.incrementStats(endpoint, StatsCircuitOpen)
-		return ClientError{ErrorCircuitOpen, *endpoint}
+		return ClientError{Message: ErrorCircuitOpen, URL: *endpoint}
 	case nil:
 		c.incrementStats(endpoint, StatsSuccess)
 		return nil
 	default:
-		return ClientError{err.Error(), *endpoint}
+		c.incrementStats(endpoint, StatsError)
+		return ClientError{Message: err.Error(), URL: *endpoint, Err: err}
 	}
 }
 
+// scatter performs the work against each of the endpoints concurrently, the
+// channel is buffered so work which completes after the caller has stopped
+// reading does not block
+func (c *ClientImpl) scatter(ctx context.Context, endpoints []url.URL, work ContextWorkFunc) <-chan EndpointResult {
+	resultChan := make(chan EndpointResult, len(endpoints))
+	slot := bulkheadSlotFromContext(ctx)
+
+	for _, endpoint := range endpoints {
+		hold := slot.hold()
+		go func(endpoint url.URL) {
+			defer hold.finish()
+
+			err := c.settings().retry.run(ctx, func(attempt int, backoff time.Duration) error {
+				return c.doEndpoint(ctx, endpoint, attempt, backoff, work)
+			})
+
+			resultChan <- EndpointResult{Endpoint: endpoint, Err: err}
+		}(endpoint)
+	}
+
+	return resultChan
+}
+
+func (c *ClientImpl) nextEndpoint() url.URL {
+	c.lbLock.Lock()
+	defer c.lbLock.Unlock()
+
+	c.syncEndpoints()
+	return c.loadbalancingStrategy.NextEndpoint()
+}
+
+func (c *ClientImpl) endpoints() []url.URL {
+	c.lbLock.Lock()
+	defer c.lbLock.Unlock()
+
+	c.syncEndpoints()
+	return c.loadbalancingStrategy.GetEndpoints()
+}
+
+func (c *ClientImpl) acquireAsyncSlot() bool {
+	if c.asyncSlots == nil {
+		return true
+	}
+
+	select {
+	case c.asyncSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *ClientImpl) releaseAsyncSlot() {
+	if c.asyncSlots != nil {
+		<-c.asyncSlots
+	}
+}
+
+func (c *ClientImpl) allCircuitsOpen() bool {
+	for _, endpoint := range c.endpoints() {
+		circuit, _, err := hystrix.GetCircuit(endpoint.String())
+		if err != nil || !circuit.IsOpen() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isCircuitOpen(err error) bool {
+	var clientErr ClientError
+	return errors.As(err, &clientErr) && clientErr.Message == ErrorCircuitOpen
+}
+
 func (c *ClientImpl) timingStats(endpoint *url.URL, duration time.Duration, action string) {
+	c.timingTaggedStats(c.statsTags(endpoint), duration, action)
+}
+
+func (c *ClientImpl) timingTaggedStats(tags []string, duration time.Duration, action string) {
 	bucket := fmt.Sprintf("%v.%v",
-		c.config.StatsD.Prefix,
+		c.settings().config.StatsD.Prefix,
 		action)
 
-	tags := append(c.config.StatsD.Tags, "server:"+PrettyPrintURL(endpoint))
 	for _, stats := range c.statsCollection {
 		stats.Timing(bucket, tags, duration, 1)
 	}
 }
 
+func (c *ClientImpl) gaugeStats(endpoint *url.URL, value float64, action string) {
+	bucket := fmt.Sprintf("%v.%v",
+		c.settings().config.StatsD.Prefix,
+		action)
+
+	tags := c.statsTags(endpoint)
+	for _, stats := range c.statsCollection {
+		if gauge, ok := stats.(GaugeStats); ok {
+			gauge.Gauge(bucket, tags, value, 1)
+		}
+	}
+}
+
+// publishEndpointGauges publishes the number of endpoints and open circuits,
+// it is called when the endpoints change rather than for each request
+func (c *ClientImpl) publishEndpointGauges() {
+	if !c.hasGaugeStats() {
+		return
+	}
+
+	endpoints := c.settings().config.Endpoints
+	open := c.openCircuits.prune(endpoints)
+
+	c.gaugeStats(nil, float64(len(endpoints)), StatsEndpoints)
+	c.gaugeStats(nil, float64(open), StatsOpenCircuits)
+}
+
+// circuitStats publishes the number of open circuits when the result of the
+// command shows the circuit for the endpoint has opened or closed
+func (c *ClientImpl) circuitStats(endpoint *url.URL, err error) {
+	if !c.hasGaugeStats() {
+		return
+	}
+
+	var open bool
+	switch err {
+	case hystrix.ErrCircuitOpen:
+		open = true
+	case nil:
+		open = false
+	default:
+		// a failure does not show the state of the circuit
+		return
+	}
+
+	if count, changed := c.openCircuits.update(endpoint.String(), open); changed {
+		c.gaugeStats(nil, float64(count), StatsOpenCircuits)
+	}
+}
+
+func (c *ClientImpl) hasGaugeStats() bool {
+	for _, stats := range c.statsCollection {
+		if _, ok := stats.(GaugeStats); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attemptStats records the attempts made by a call and the retries of the
+// call with stats which implement ExtendedStats
+func (c *ClientImpl) attemptStats(attempts int) {
+	bucket := fmt.Sprintf("%v.%v",
+		c.settings().config.StatsD.Prefix,
+		StatsAttempts)
+	retryBucket := fmt.Sprintf("%v.%v",
+		c.settings().config.StatsD.Prefix,
+		StatsRetry)
+
+	tags := c.statsTags(nil)
+	for _, stats := range c.statsCollection {
+		extended, ok := stats.(ExtendedStats)
+		if !ok {
+			continue
+		}
+
+		extended.Histogram(bucket, tags, float64(attempts), 1)
+		if attempts > 1 {
+			extended.Count(retryBucket, tags, int64(attempts-1), 1)
+		}
+	}
+}
+
+// openCircuits records the endpoints which were last seen with an open
+// circuit so the gauge only changes when a circuit opens or closes
+type openCircuits struct {
+	sync.Mutex
+	open map[string]bool
+}
+
+func newOpenCircuits() *openCircuits {
+	return &openCircuits{open: map[string]bool{}}
+}
+
+// update records the state of the circuit for the endpoint, it returns the
+// number of open circuits and whether the state of the endpoint changed
+func (o *openCircuits) update(endpoint string, open bool) (int, bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.open[endpoint] == open {
+		return len(o.open), false
+	}
+
+	if open {
+		o.open[endpoint] = true
+	} else {
+		delete(o.open, endpoint)
+	}
+
+	return len(o.open), true
+}
+
+// prune forgets the circuits of endpoints which have been removed, it
+// returns the number of open circuits
+func (o *openCircuits) prune(endpoints []url.URL) int {
+	o.Lock()
+	defer o.Unlock()
+
+	current := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		current[endpoint.String()] = true
+	}
+
+	for endpoint := range o.open {
+		if !current[endpoint] {
+			delete(o.open, endpoint)
+		}
+	}
+
+	return len(o.open)
+}
+
 func (c *ClientImpl) incrementStats(endpoint *url.URL, action string) {
+	c.incrementTaggedStats(c.statsTags(endpoint), action)
+}
+
+func (c *ClientImpl) incrementTaggedStats(tags []string, action string) {
 	bucket := fmt.Sprintf("%v.%v",
-		c.config.StatsD.Prefix,
+		c.settings().config.StatsD.Prefix,
 		action)
 
-	tags := append(c.config.StatsD.Tags, "server:"+PrettyPrintURL(endpoint))
 	for _, stats := range c.statsCollection {
 		stats.Increment(bucket, tags, 1)
 	}
 }
 
-// NewClient creates a new instance of the loadbalancing client
+// statsTags returns the configured tags and the server tag for the endpoint,
+// stats which are not related to an endpoint pass a nil endpoint.  The
+// capacity of the returned slice is capped so tags appended by the caller are
+// copied to a new slice rather than written into the configured tags which
+// are shared by every goroutine using the client.
+func (c *ClientImpl) statsTags(endpoint *url.URL) []string {
+	tags := c.settings().config.StatsD.Tags
+	tags = tags[:len(tags):len(tags)]
+
+	if endpoint == nil {
+		return tags
+	}
+
+	return append(tags, "server:"+PrettyPrintURL(endpoint))
+}
+
+// NewClient creates a new instance of the loadbalancing client, the
+// configuration is not validated, use New to create a client with validation
 func NewClient(
 	config Config,
 	loadbalancingStrategy LoadbalancingStrategy,
 	backoffStrategy BackoffStrategy) Client {
 
-	loadbalancingStrategy.SetEndpoints(config.Endpoints)
+	return newClient(&options{
+		config:                config,
+		loadbalancingStrategy: loadbalancingStrategy,
+		backoffStrategy:       backoffStrategy,
+	})
+}
+
+func newClient(o *options) *ClientImpl {
+	config := o.config
+	loadbalancingStrategy := o.loadbalancingStrategy
+	backoffStrategy := o.backoffStrategy
+
+	metadata := defaultMetadata(config.Endpoints)
+	setEndpoints(loadbalancingStrategy, config.Endpoints, metadata)
 
 	if config.Retries < 1 {
 		config.Retries = loadbalancingStrategy.Length() - 1
 	}
 
 	client := &ClientImpl{
-		config:                config,
 		loadbalancingStrategy: loadbalancingStrategy,
-		backoffStrategy:       backoffStrategy,
+		inFlight:              &atomic.Int64{},
+		openCircuits:          newOpenCircuits(),
+		sharedCalls:           newSharedCalls(),
+		cachedCalls:           newSharedCalls(),
 	}
 
-	for _, url := range loadbalancingStrategy.GetEndpoints() {
-		hystrix.ConfigureCommand(url.String(), hystrix.CommandConfig{
-			Timeout:                int(config.Timeout / time.Millisecond),
-			MaxConcurrentRequests:  config.MaxConcurrentRequests,
-			ErrorPercentThreshold:  config.ErrorPercentThreshold,
-			RequestVolumeThreshold: config.DefaultVolumeThreshold,
-		})
+	configureCommands(loadbalancingStrategy.GetEndpoints(), config)
+	client.clientSettings = newClientSettings(config, metadata, backoffStrategy)
+
+	client.statsCollection = append(make([]Stats, 0), o.stats...)
+	client.tracer = o.tracer
+
+	if config.MaxConcurrentRequests > 0 {
+		client.asyncSlots = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+
+	if config.Cache.Size > 0 {
+		client.cache = newResponseCache(config.Cache)
 	}
 
-	client.retry = retrier.New(backoffStrategy.Create(client.config.Retries, client.config.RetryDelay), nil)
+	client.rateLimiters = newRateLimiters(config.RateLimit, config.EndpointRateLimit)
+	client.concurrencyLimiters = newConcurrencyLimiters(config.AdaptiveConcurrency)
+	client.loadShedder = newLoadShedder(config)
+	client.bulkheads = newBulkheads(config.Bulkheads, config.DefaultBulkhead)
 
-	client.statsCollection = make([]Stats, 0)
+	client.publishEndpointGauges()
+	client.publishConcurrencyLimit()
 
 	return client
 }
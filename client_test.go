@@ -1,8 +1,10 @@
 package ultraclient
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
@@ -242,6 +244,103 @@ func TestOpenCircuitIncrementsStats(t *testing.T) {
 		"myapp.circuitopen", tags1, mock.Anything)
 }
 
+func setupExtendedStats() *MockExtendedStats {
+	stats := &MockExtendedStats{}
+	stats.On("Timing", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Increment", mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Histogram", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Count", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	client.RegisterStats(stats)
+
+	return stats
+}
+
+func TestDoPublishesInFlightGauge(t *testing.T) {
+	setupClient(0)
+	stats := setupExtendedStats()
+	tags := client.settings().config.StatsD.Tags
+
+	client.Do(func(endpoint url.URL) error {
+		stats.AssertCalled(t, "Gauge", "myapp.inflight", tags, float64(1), mock.Anything)
+		return nil
+	})
+
+	stats.AssertCalled(t, "Gauge", "myapp.inflight", tags, float64(0), mock.Anything)
+}
+
+func TestUpdateEndpointsPublishesEndpointGauge(t *testing.T) {
+	setupClient(0)
+	stats := setupExtendedStats()
+	tags := client.settings().config.StatsD.Tags
+
+	client.UpdateEndpoints(urls)
+
+	stats.AssertCalled(t, "Gauge", "myapp.endpoints", tags, float64(len(urls)), mock.Anything)
+	stats.AssertCalled(t, "Gauge", "myapp.opencircuits", tags, float64(0), mock.Anything)
+}
+
+func TestDoDoesNotPublishEndpointGaugeForEachRequest(t *testing.T) {
+	setupClient(0)
+	stats := setupExtendedStats()
+
+	for i := 0; i < 3; i++ {
+		client.Do(func(endpoint url.URL) error {
+			return nil
+		})
+	}
+
+	assert.Equal(t, 1, countGauges(stats, "myapp.endpoints"))
+	assert.Equal(t, 1, countGauges(stats, "myapp.opencircuits"))
+}
+
+func TestDoPublishesOpenCircuitGaugeWhenCircuitOpens(t *testing.T) {
+	setupClient(5)
+	stats := setupExtendedStats()
+	tags := client.settings().config.StatsD.Tags
+
+	client.Do(func(endpoint url.URL) error {
+		time.Sleep(150 * time.Millisecond)
+		return nil
+	})
+
+	stats.AssertCalled(t, "Gauge", "myapp.opencircuits", tags, float64(len(urls)), mock.Anything)
+}
+
+func TestDoPublishesAttemptHistogramAndRetryCount(t *testing.T) {
+	setupClient(1)
+	stats := setupExtendedStats()
+	tags := client.settings().config.StatsD.Tags
+
+	client.Do(func(endpoint url.URL) error {
+		return fmt.Errorf("boom")
+	})
+
+	stats.AssertCalled(t, "Histogram", "myapp.attempts", tags, float64(2), mock.Anything)
+	stats.AssertCalled(t, "Count", "myapp.retry", tags, int64(1), mock.Anything)
+}
+
+func countGauges(stats *MockExtendedStats, name string) int {
+	count := 0
+	for _, call := range stats.Calls {
+		if call.Method == "Gauge" && call.Arguments.Get(0) == name {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestDoDoesNotPublishGaugesToStats(t *testing.T) {
+	setupClient(0)
+
+	client.Do(func(endpoint url.URL) error {
+		return nil
+	})
+
+	mockStats.AssertNotCalled(t, "Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestCloneCreatesACloneOfTheClient(t *testing.T) {
 	setupClient(0)
 	c := client.Clone().(*ClientImpl)
@@ -254,3 +353,470 @@ func TestCloneCreatesACloneOfTheClient(t *testing.T) {
 	assert.Equal(t, client.statsCollection, c.statsCollection)
 	assert.Equal(t, client.retry, c.retry)
 }
+
+func TestDoWaitsForRetryAfterHint(t *testing.T) {
+	setupClient(1)
+
+	callCount := 0
+	startTime := time.Now()
+	err := client.Do(func(endpoint url.URL) error {
+		callCount++
+		if callCount == 1 {
+			return RetryAfter(fmt.Errorf("too many requests"), 50*time.Millisecond)
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, callCount)
+	assert.True(t, time.Now().Sub(startTime) >= 50*time.Millisecond)
+}
+
+func TestDoCapsRetryAfterHintWithMaxRetryAfter(t *testing.T) {
+	setupClient(1)
+	client.retry.maxRetryAfter = 10 * time.Millisecond
+
+	startTime := time.Now()
+	err := client.Do(func(endpoint url.URL) error {
+		return RetryAfter(fmt.Errorf("too many requests"), 10*time.Second)
+	})
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Now().Sub(startTime) < 1*time.Second)
+}
+
+func TestRetryPolicyCapsRetryAfterHintByDefault(t *testing.T) {
+	retry := newRetryPolicy([]time.Duration{time.Millisecond}, 0)
+
+	delay := retry.delay(0, RetryAfter(fmt.Errorf("too many requests"), 1*time.Hour))
+
+	assert.Equal(t, DefaultMaxRetryAfter, delay)
+}
+
+func TestDoReturnsWorkErrorInClientError(t *testing.T) {
+	setupClient(0)
+	workErr := fmt.Errorf("aaah")
+
+	err := client.Do(func(endpoint url.URL) error {
+		return workErr
+	})
+
+	assert.Equal(t, workErr, err.(ClientError).Err)
+}
+
+func TestDoDoesNotRetryNonRetryableErrors(t *testing.T) {
+	setupClient(2)
+
+	callCount := 0
+	err := client.Do(func(endpoint url.URL) error {
+		callCount++
+		return NonRetryable(fmt.Errorf("bad request"))
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, callCount)
+}
+
+func TestDoDoesNotRetryOrOpenCircuitForBadRequests(t *testing.T) {
+	setupClient(2)
+
+	callCount := 0
+	for i := 0; i < 5; i++ {
+		err := client.Do(func(endpoint url.URL) error {
+			callCount++
+			return BadRequest(fmt.Errorf("not found"))
+		})
+
+		assert.NotNil(t, err)
+	}
+
+	circuit, _, _ := hystrix.GetCircuit(urls[0].String())
+
+	assert.Equal(t, 5, callCount)
+	assert.False(t, circuit.IsOpen())
+}
+
+func TestErrorIncrementsStats(t *testing.T) {
+	setupClient(0)
+
+	err := client.Do(func(endpoint url.URL) error {
+		return fmt.Errorf("aaah")
+	})
+
+	assert.NotNil(t, err)
+
+	tags := append(client.config.StatsD.Tags, "server:something_3232")
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.error", tags, mock.Anything)
+}
+
+type contextKey string
+
+func TestDoContextPassesContextToWork(t *testing.T) {
+	setupClient(0)
+	ctx := context.WithValue(context.Background(), contextKey("key"), "value")
+
+	var value interface{}
+	err := client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		value = ctx.Value(contextKey("key"))
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestDoContextStopsRetryingWhenContextIsDone(t *testing.T) {
+	setupClient(2)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	callCount := 0
+	err := client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		callCount++
+		cancel()
+		return ctx.Err()
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, callCount)
+}
+
+func TestDoContextReturnsErrorWhenContextIsAlreadyDone(t *testing.T) {
+	setupClient(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	callCount := 0
+	err := client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		callCount++
+		return nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, callCount)
+}
+
+func TestDoWithFallbackCallsFallbackWhenRetriesExhausted(t *testing.T) {
+	setupClient(1)
+
+	callCount := 0
+	var fallbackErr error
+	err := client.DoWithFallback(func(endpoint url.URL) error {
+		callCount++
+		return fmt.Errorf("aaah")
+	}, func(err error) error {
+		fallbackErr = err
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, callCount)
+	assert.NotNil(t, fallbackErr)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.fallbacksuccess", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestDoWithFallbackDoesNotCallFallbackOnSuccess(t *testing.T) {
+	setupClient(0)
+
+	err := client.DoWithFallback(func(endpoint url.URL) error {
+		return nil
+	}, func(err error) error {
+		t.Fatal("fallback should not be called")
+		return nil
+	})
+
+	assert.Nil(t, err)
+}
+
+func TestDoWithFallbackDoesNotCallFallbackForBadRequests(t *testing.T) {
+	setupClient(0)
+
+	err := client.DoWithFallback(func(endpoint url.URL) error {
+		return BadRequest(fmt.Errorf("not found"))
+	}, func(err error) error {
+		t.Fatal("fallback should not be called")
+		return nil
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestDoWithFallbackReturnsFallbackError(t *testing.T) {
+	setupClient(0)
+	fallbackErr := fmt.Errorf("no cache")
+
+	err := client.DoWithFallback(func(endpoint url.URL) error {
+		return fmt.Errorf("aaah")
+	}, func(err error) error {
+		return fallbackErr
+	})
+
+	assert.Equal(t, fallbackErr, err)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.fallbackerror", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestDoWithFallbackCallsFallbackImmediatelyWhenAllCircuitsOpen(t *testing.T) {
+	setupClient(5)
+
+	for i := 0; i < 2; i++ {
+		client.Do(func(endpoint url.URL) error {
+			return fmt.Errorf("aaah")
+		})
+	}
+
+	callsBefore := countCalls(&loadbalancingStrategy.Mock, "NextEndpoint")
+	fallbackCalled := false
+	err := client.DoWithFallback(func(endpoint url.URL) error {
+		return nil
+	}, func(err error) error {
+		fallbackCalled = true
+		assert.Equal(t, ErrorCircuitOpen, err.(ClientError).Message)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, fallbackCalled)
+	assert.Equal(t, 1, countCalls(&loadbalancingStrategy.Mock, "NextEndpoint")-callsBefore)
+}
+
+func countCalls(m *mock.Mock, method string) int {
+	count := 0
+	for _, call := range m.Calls {
+		if call.Method == method {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestDoAsyncReturnsResultOnChannel(t *testing.T) {
+	setupClient(0)
+
+	errChan := client.DoAsync(func(endpoint url.URL) error {
+		return nil
+	})
+
+	assert.Nil(t, <-errChan)
+	_, open := <-errChan
+	assert.False(t, open)
+}
+
+func TestDoAsyncReturnsErrorOnChannel(t *testing.T) {
+	setupClient(0)
+
+	errChan := client.DoAsync(func(endpoint url.URL) error {
+		return fmt.Errorf("aaah")
+	})
+
+	assert.NotNil(t, <-errChan)
+}
+
+func TestDoAsyncRunsWorkConcurrently(t *testing.T) {
+	setupClient(0)
+
+	first := make(chan struct{})
+	firstErr := client.DoAsync(func(endpoint url.URL) error {
+		<-first
+		return nil
+	})
+
+	secondErr := client.DoAsync(func(endpoint url.URL) error {
+		close(first)
+		return nil
+	})
+
+	assert.Nil(t, <-secondErr)
+	assert.Nil(t, <-firstErr)
+}
+
+func TestDoAsyncRejectsWorkWhenMaxConcurrencyReached(t *testing.T) {
+	setupClient(0)
+	client.asyncSlots = make(chan struct{}, 1)
+
+	release := make(chan struct{})
+	firstErr := client.DoAsync(func(endpoint url.URL) error {
+		<-release
+		return nil
+	})
+
+	secondErr := client.DoAsync(func(endpoint url.URL) error {
+		return nil
+	})
+
+	assert.Equal(t, ErrorMaxConcurrency, (<-secondErr).(ClientError).Message)
+	close(release)
+	<-firstErr
+
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.maxconcurrency", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestDoAllPerformsWorkAgainstEveryEndpoint(t *testing.T) {
+	setupClient(0)
+
+	var lock sync.Mutex
+	hosts := map[string]int{}
+	results, err := client.DoAll(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		hosts[endpoint.Host]++
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, map[string]int{"something:3232": 1, "somethingelse:2323": 1}, hosts)
+}
+
+func TestDoAllRetriesAgainstTheSameEndpoint(t *testing.T) {
+	setupClient(1)
+
+	var lock sync.Mutex
+	hosts := map[string]int{}
+	_, err := client.DoAll(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		hosts[endpoint.Host]++
+		if endpoint.Host == "something:3232" && hosts[endpoint.Host] == 1 {
+			return fmt.Errorf("aaah")
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"something:3232": 2, "somethingelse:2323": 1}, hosts)
+}
+
+func TestDoAllReturnsErrorWhenAnyEndpointFails(t *testing.T) {
+	setupClient(0)
+
+	results, err := client.DoAll(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		if endpoint.Host == "something:3232" {
+			return fmt.Errorf("aaah")
+		}
+
+		return nil
+	})
+
+	assert.Equal(t, ErrorQuorumNotReached, err.(ClientError).Message)
+	assert.Equal(t, 2, len(results))
+}
+
+func TestConcurrentCallsDoNotShareServerTags(t *testing.T) {
+	setupClient(0)
+
+	// spare capacity lets an append write into the configured tags
+	tags := make([]string, 1, 8)
+	tags[0] = "env:production"
+	client.updateSettings(func(settings *clientSettings) {
+		settings.config.StatsD.Tags = tags
+	})
+
+	// keep the circuits closed, hystrix-go races when concurrent commands
+	// close an open circuit
+	for _, u := range urls {
+		hystrix.ConfigureCommand(u.String(), hystrix.CommandConfig{
+			Timeout:                1000,
+			MaxConcurrentRequests:  100,
+			RequestVolumeThreshold: 1000,
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.DoAll(context.Background(), noopWork)
+		}()
+	}
+	wg.Wait()
+
+	servers := map[string]int{}
+	for _, call := range mockStats.Calls {
+		if call.Method == "Increment" && call.Arguments.Get(0) == "myapp.called" {
+			servers[call.Arguments.Get(1).([]string)[1]]++
+		}
+	}
+
+	assert.Equal(t, map[string]int{"server:something_3232": 20, "server:somethingelse_2323": 20}, servers)
+	assert.Equal(t, []string{"env:production"}, tags)
+}
+
+// setupQuorumClient gives the client its own stats, DoQuorum returns while
+// work is still in flight which would otherwise record to the stats of the
+// next test
+func setupQuorumClient() {
+	setupClient(0)
+
+	stats := &MockStats{}
+	stats.On("Timing", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Increment", mock.Anything, mock.Anything, mock.Anything)
+	client.statsCollection = []Stats{stats}
+}
+
+func TestDoQuorumReturnsWhenQuorumReached(t *testing.T) {
+	setupQuorumClient()
+
+	results, err := client.DoQuorum(context.Background(), 1, func(ctx context.Context, endpoint url.URL) error {
+		if endpoint.Host == "something:3232" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "somethingelse:2323", results[0].Endpoint.Host)
+}
+
+func TestDoQuorumReturnsErrorWhenQuorumCanNotBeReached(t *testing.T) {
+	setupQuorumClient()
+
+	_, err := client.DoQuorum(context.Background(), 2, func(ctx context.Context, endpoint url.URL) error {
+		if endpoint.Host == "something:3232" {
+			return fmt.Errorf("aaah")
+		}
+
+		return nil
+	})
+
+	assert.Equal(t, ErrorQuorumNotReached, err.(ClientError).Message)
+}
+
+func TestDoQuorumReturnsErrorForInvalidQuorum(t *testing.T) {
+	setupClient(0)
+
+	_, err := client.DoQuorum(context.Background(), 3, func(ctx context.Context, endpoint url.URL) error {
+		return nil
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestConfiguredEndpointReachesWorkUnchanged(t *testing.T) {
+	endpoint, _ := url.Parse("http://api:8080/search?zone=eu&b=2&a=1")
+	c, err := New(WithEndpoints(*endpoint))
+	assert.Nil(t, err)
+
+	var received url.URL
+	c.Do(func(endpoint url.URL) error {
+		received = endpoint
+		return nil
+	})
+
+	assert.Equal(t, "http://api:8080/search?zone=eu&b=2&a=1", received.String())
+}
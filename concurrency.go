@@ -0,0 +1,256 @@
+package ultraclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+var errConcurrencyLimited = errors.New(ErrorConcurrencyLimited)
+
+// acquireConcurrency takes a slot from the adaptive concurrency limit before
+// work is performed against the endpoint.  Work rejected by the client limit
+// is not retried, work rejected by an endpoint limit is retried so that it can
+// be performed against another endpoint.
+func (c *ClientImpl) acquireConcurrency(endpoint *url.URL) (*aimdLimiter, error) {
+	if c.concurrencyLimiters == nil {
+		return nil, nil
+	}
+
+	limiter, created := c.concurrencyLimiters.limiter(endpoint)
+	if created {
+		c.gaugeStats(endpoint, float64(limiter.currentLimit()), StatsConcurrencyLimit)
+	}
+
+	if limiter.acquire() {
+		return limiter, nil
+	}
+
+	if c.concurrencyLimiters.perEndpoint {
+		c.incrementStats(endpoint, StatsConcurrencyLimited)
+		return nil, ClientError{Message: ErrorConcurrencyLimited, URL: *endpoint}
+	}
+
+	c.incrementStats(nil, StatsConcurrencyLimited)
+	return nil, ClientError{
+		Message: ErrorConcurrencyLimited,
+		URL:     *endpoint,
+		Err:     NonRetryable(errConcurrencyLimited),
+	}
+}
+
+// releaseConcurrency returns the slot to the limiter and adjusts the limit
+// from the outcome of the work, the new limit is reported as a gauge
+func (c *ClientImpl) releaseConcurrency(
+	ctx context.Context,
+	limiter *aimdLimiter,
+	endpoint *url.URL,
+	err error,
+	duration time.Duration) {
+
+	if limiter == nil {
+		return
+	}
+
+	limit, changed := limiter.release(outcome(ctx, err, duration, limiter.latencyThreshold))
+	if !changed {
+		return
+	}
+
+	if c.concurrencyLimiters.perEndpoint {
+		c.gaugeStats(endpoint, float64(limit), StatsConcurrencyLimit)
+		return
+	}
+
+	c.gaugeStats(nil, float64(limit), StatsConcurrencyLimit)
+}
+
+// publishConcurrencyLimit reports the starting limit of the client as a
+// gauge, the limit of each endpoint is reported when its limiter is created
+func (c *ClientImpl) publishConcurrencyLimit() {
+	if c.concurrencyLimiters == nil || c.concurrencyLimiters.perEndpoint {
+		return
+	}
+
+	c.gaugeStats(nil, float64(c.concurrencyLimiters.client.currentLimit()), StatsConcurrencyLimit)
+}
+
+type limitOutcome int
+
+const (
+	// outcomeIgnored work did not reach the endpoint and says nothing about
+	// its capacity
+	outcomeIgnored limitOutcome = iota
+	outcomeSuccess
+	outcomeDropped
+)
+
+// outcome classifies the result of work, work the caller gave up on says
+// nothing about the capacity of the endpoint
+func outcome(ctx context.Context, err error, duration, latencyThreshold time.Duration) limitOutcome {
+	switch {
+	case err == hystrix.ErrCircuitOpen, err == hystrix.ErrMaxConcurrency:
+		return outcomeIgnored
+	case ctx.Err() != nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return outcomeIgnored
+	case err != nil && !isBadRequest(err):
+		return outcomeDropped
+	case latencyThreshold > 0 && duration > latencyThreshold:
+		return outcomeDropped
+	default:
+		return outcomeSuccess
+	}
+}
+
+// concurrencyLimiters holds the limiter for the client or a limiter for each
+// endpoint, it is shared between a client and its clones
+type concurrencyLimiters struct {
+	config      AdaptiveConcurrency
+	perEndpoint bool
+	client      *aimdLimiter
+
+	sync.Mutex
+	endpoints map[string]*aimdLimiter
+}
+
+func newConcurrencyLimiters(config AdaptiveConcurrency) *concurrencyLimiters {
+	if config.InitialLimit <= 0 {
+		return nil
+	}
+
+	c := &concurrencyLimiters{
+		config:      config,
+		perEndpoint: config.PerEndpoint,
+		endpoints:   make(map[string]*aimdLimiter),
+	}
+
+	if !config.PerEndpoint {
+		c.client = newAIMDLimiter(config)
+	}
+
+	return c
+}
+
+// limiter returns the limiter for the endpoint, created is true when the
+// limiter for the endpoint was created by the call
+func (c *concurrencyLimiters) limiter(endpoint *url.URL) (limiter *aimdLimiter, created bool) {
+	if !c.perEndpoint {
+		return c.client, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	limiter, ok := c.endpoints[endpoint.String()]
+	if !ok {
+		limiter = newAIMDLimiter(c.config)
+		c.endpoints[endpoint.String()] = limiter
+	}
+
+	return limiter, !ok
+}
+
+// prune removes the limiters of endpoints which are no longer used by the
+// client, work in flight releases its slot to the limiter it took it from
+func (c *concurrencyLimiters) prune(endpoints []url.URL) {
+	c.Lock()
+	defer c.Unlock()
+
+	current := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		current[endpoint.String()] = true
+	}
+
+	for endpoint := range c.endpoints {
+		if !current[endpoint] {
+			delete(c.endpoints, endpoint)
+		}
+	}
+}
+
+// aimdLimiter is an additive increase multiplicative decrease concurrency
+// limiter, the limit grows by one for each successful request made while at
+// least half of the limit is in use and shrinks by the backoff ratio for each
+// dropped request
+type aimdLimiter struct {
+	sync.Mutex
+	limit            float64
+	minLimit         float64
+	maxLimit         float64
+	backoffRatio     float64
+	latencyThreshold time.Duration
+	inFlight         int
+}
+
+func newAIMDLimiter(config AdaptiveConcurrency) *aimdLimiter {
+	l := &aimdLimiter{
+		minLimit:         float64(config.MinLimit),
+		maxLimit:         float64(config.MaxLimit),
+		backoffRatio:     config.BackoffRatio,
+		latencyThreshold: config.LatencyThreshold,
+	}
+
+	if l.minLimit < 1 {
+		l.minLimit = 1
+	}
+
+	if l.maxLimit <= 0 {
+		l.maxLimit = 1000
+	}
+
+	if l.backoffRatio <= 0 || l.backoffRatio >= 1 {
+		l.backoffRatio = 0.9
+	}
+
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, float64(config.InitialLimit)))
+
+	return l
+}
+
+func (l *aimdLimiter) acquire() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.inFlight >= int(l.limit) {
+		return false
+	}
+
+	l.inFlight++
+	return true
+}
+
+// release returns a slot to the limiter and adjusts the limit, changed is true
+// when the whole number limit has moved
+func (l *aimdLimiter) release(result limitOutcome) (limit int, changed bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+
+	previous := int(l.limit)
+
+	switch result {
+	case outcomeDropped:
+		l.limit = math.Max(l.minLimit, l.limit*l.backoffRatio)
+	case outcomeSuccess:
+		if float64(inFlight*2) >= l.limit {
+			l.limit = math.Min(l.maxLimit, l.limit+1)
+		}
+	}
+
+	return int(l.limit), int(l.limit) != previous
+}
+
+// currentLimit returns the number of requests currently allowed in flight
+func (l *aimdLimiter) currentLimit() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return int(l.limit)
+}
@@ -0,0 +1,203 @@
+package ultraclient
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupConcurrencyLimit(config AdaptiveConcurrency) *MockGaugeStats {
+	client.concurrencyLimiters = newConcurrencyLimiters(config)
+
+	gaugeStats := &MockGaugeStats{}
+	gaugeStats.On("Timing", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	gaugeStats.On("Increment", mock.Anything, mock.Anything, mock.Anything)
+	gaugeStats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	client.RegisterStats(gaugeStats)
+
+	return gaugeStats
+}
+
+func TestConcurrencyLimitRejectsWorkOverTheLimit(t *testing.T) {
+	setupClient(2)
+	setupConcurrencyLimit(AdaptiveConcurrency{InitialLimit: 1})
+
+	// work already in flight holds the only slot
+	client.concurrencyLimiters.client.acquire()
+
+	callCount := 0
+	err := client.Do(func(endpoint url.URL) error {
+		callCount++
+		return nil
+	})
+
+	assert.Equal(t, ErrorConcurrencyLimited, err.(ClientError).Message)
+	assert.Equal(t, 0, callCount)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.concurrencylimited", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestConcurrencyLimitDecreasesOnErrorAndReportsGauge(t *testing.T) {
+	setupClient(0)
+	gaugeStats := setupConcurrencyLimit(AdaptiveConcurrency{InitialLimit: 10, BackoffRatio: 0.5})
+
+	client.Do(func(endpoint url.URL) error {
+		return errors.New("boom")
+	})
+
+	assert.Equal(t, 5, client.concurrencyLimiters.client.currentLimit())
+	gaugeStats.AssertCalled(t,
+		"Gauge",
+		"myapp.concurrencylimit", client.config.StatsD.Tags, float64(5), mock.Anything)
+}
+
+func TestConcurrencyLimitDecreasesOnSlowWork(t *testing.T) {
+	setupClient(0)
+	setupConcurrencyLimit(AdaptiveConcurrency{
+		InitialLimit:     10,
+		BackoffRatio:     0.5,
+		LatencyThreshold: 1 * time.Microsecond,
+	})
+
+	err := client.Do(func(endpoint url.URL) error {
+		time.Sleep(1 * time.Millisecond)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, client.concurrencyLimiters.client.currentLimit())
+}
+
+func TestConcurrencyLimitIsKeptPerEndpoint(t *testing.T) {
+	setupClient(0)
+	gaugeStats := setupConcurrencyLimit(AdaptiveConcurrency{
+		InitialLimit: 10,
+		BackoffRatio: 0.5,
+		PerEndpoint:  true,
+	})
+
+	client.Do(func(endpoint url.URL) error {
+		return errors.New("boom")
+	})
+
+	something, _ := client.concurrencyLimiters.limiter(&urls[0])
+	somethingElse, _ := client.concurrencyLimiters.limiter(&urls[1])
+
+	assert.Equal(t, 5, something.currentLimit())
+	assert.Equal(t, 10, somethingElse.currentLimit())
+
+	tags := append(client.config.StatsD.Tags, "server:something_3232")
+	gaugeStats.AssertCalled(t, "Gauge", "myapp.concurrencylimit", tags, float64(5), mock.Anything)
+}
+
+func TestEndpointConcurrencyLimitersAreRemovedWithTheirEndpoints(t *testing.T) {
+	setupClient(0)
+	setupConcurrencyLimit(AdaptiveConcurrency{InitialLimit: 10, PerEndpoint: true})
+
+	work := func(endpoint url.URL) error {
+		return nil
+	}
+
+	assert.Nil(t, client.Do(work))
+	client.UpdateEndpoints([]url.URL{urls[1]})
+	assert.Nil(t, client.Do(work))
+
+	assert.Len(t, client.concurrencyLimiters.endpoints, 1)
+	assert.NotNil(t, client.concurrencyLimiters.endpoints[urls[1].String()])
+}
+
+func TestConcurrencyLimitIgnoresCancelledWork(t *testing.T) {
+	setupClient(0)
+	setupConcurrencyLimit(AdaptiveConcurrency{InitialLimit: 1, MaxLimit: 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		cancel()
+		return errors.New("request cancelled")
+	})
+
+	assert.Equal(t, 1, client.concurrencyLimiters.client.currentLimit())
+}
+
+func TestConcurrencyLimitIsReportedWhenClientIsCreated(t *testing.T) {
+	gaugeStats := &MockGaugeStats{}
+	gaugeStats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	config := validConfig()
+	config.AdaptiveConcurrency = AdaptiveConcurrency{InitialLimit: 20}
+	_, err := New(WithConfig(config), WithStats("myapp", nil, gaugeStats))
+
+	assert.Nil(t, err)
+	gaugeStats.AssertCalled(t, "Gauge", "myapp.concurrencylimit", []string(nil), float64(20), mock.Anything)
+}
+
+func TestConcurrencyLimitIsReportedWhenEndpointLimiterIsCreated(t *testing.T) {
+	setupClient(0)
+	gaugeStats := setupConcurrencyLimit(AdaptiveConcurrency{InitialLimit: 10, PerEndpoint: true})
+
+	client.Do(func(endpoint url.URL) error {
+		return nil
+	})
+
+	tags := append(client.config.StatsD.Tags, "server:something_3232")
+	gaugeStats.AssertCalled(t, "Gauge", "myapp.concurrencylimit", tags, float64(10), mock.Anything)
+}
+
+func TestOutcomeIgnoresCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, outcomeIgnored, outcome(ctx, BadRequest(errors.New("cancelled")), 0, 0))
+	assert.Equal(t, outcomeIgnored, outcome(context.Background(), context.DeadlineExceeded, 0, 0))
+	assert.Equal(t, outcomeSuccess, outcome(context.Background(), BadRequest(errors.New("missing")), 0, 0))
+	assert.Equal(t, outcomeDropped, outcome(context.Background(), errors.New("boom"), 0, 0))
+}
+
+func TestAIMDLimiterIncreasesWhenLimitIsUsed(t *testing.T) {
+	limiter := newAIMDLimiter(AdaptiveConcurrency{InitialLimit: 2, MaxLimit: 3})
+
+	assert.True(t, limiter.acquire())
+	limit, changed := limiter.release(outcomeSuccess)
+	assert.Equal(t, 3, limit)
+	assert.True(t, changed)
+
+	assert.True(t, limiter.acquire())
+	limit, changed = limiter.release(outcomeSuccess)
+	assert.Equal(t, 3, limit)
+	assert.False(t, changed)
+}
+
+func TestAIMDLimiterDoesNotIncreaseWhenLimitIsUnused(t *testing.T) {
+	limiter := newAIMDLimiter(AdaptiveConcurrency{InitialLimit: 4})
+
+	assert.True(t, limiter.acquire())
+	limit, changed := limiter.release(outcomeSuccess)
+
+	assert.Equal(t, 4, limit)
+	assert.False(t, changed)
+}
+
+func TestAIMDLimiterDoesNotFallBelowMinimum(t *testing.T) {
+	limiter := newAIMDLimiter(AdaptiveConcurrency{InitialLimit: 2, MinLimit: 2, BackoffRatio: 0.5})
+
+	assert.True(t, limiter.acquire())
+	limit, _ := limiter.release(outcomeDropped)
+
+	assert.Equal(t, 2, limit)
+}
+
+func TestAIMDLimiterIgnoresCircuitOpen(t *testing.T) {
+	limiter := newAIMDLimiter(AdaptiveConcurrency{InitialLimit: 1})
+
+	assert.True(t, limiter.acquire())
+	limit, changed := limiter.release(outcomeIgnored)
+
+	assert.Equal(t, 1, limit)
+	assert.False(t, changed)
+}
@@ -0,0 +1,436 @@
+package ultraclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the configuration for a client which can be decoded from
+// JSON, YAML or environment variables, it is a Config with the names of the
+// loadbalancing and backoff strategies.  Durations are written in the form
+// accepted by time.ParseDuration such as "50ms" and endpoints as a url or as
+// host:port.
+//
+//	timeout: 50ms
+//	max_concurrent_requests: 500
+//	error_percent_threshold: 25
+//	retries: 3
+//	retry_delay: 100ms
+//	endpoints:
+//	  - server1:8080
+//	  - http://server2:8080
+//	strategy: roundrobin
+//	backoff: exponential
+//	statsd:
+//	  prefix: application.client
+//	  tags: ["env:production"]
+type FileConfig struct {
+	Config `yaml:",inline"`
+
+	Strategy string `json:"strategy" yaml:"strategy" env:"STRATEGY"`
+	Backoff  string `json:"backoff" yaml:"backoff" env:"BACKOFF"`
+}
+
+// Duration is a time.Duration which is decoded from a string such as "50ms",
+// it is used to decode the durations of a Config
+type Duration time.Duration
+
+// UnmarshalText parses the duration with time.ParseDuration
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalText writes the duration in the same form it is decoded from
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfigFile decodes the FileConfig from a JSON or YAML file, the format
+// is chosen from the extension of the file.  Settings which are not in the
+// file keep the defaults used by New, a key without a value is the zero value
+// and keys which are not settings are rejected.
+func LoadConfigFile(path string) (FileConfig, error) {
+	config := FileConfig{Config: defaultOptions().config}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	var tree interface{}
+	tag := "yaml"
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		tag = "json"
+		err = json.Unmarshal(data, &tree)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	default:
+		err = fmt.Errorf("unable to load config from %v, the file must be .json, .yaml or .yml", path)
+	}
+
+	if err == nil && tree != nil {
+		err = decodeConfig(tree, reflect.ValueOf(&config).Elem(), tag)
+	}
+
+	if err != nil {
+		return config, fmt.Errorf("unable to load config from %v: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// decodeConfig sets the value from the decoded JSON or YAML, the fields of
+// structs are named by the given tag and keys which do not name a field are
+// rejected so misspelled settings are not ignored
+func decodeConfig(tree interface{}, value reflect.Value, tag string) error {
+	// a key without a value such as an empty statsd: is the zero value
+	if tree == nil {
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	}
+
+	switch value.Interface().(type) {
+	case time.Duration, url.URL:
+		text, ok := tree.(string)
+		if !ok {
+			return fmt.Errorf("expected a string but got %v", tree)
+		}
+
+		return setField(value, text)
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		fields, ok := tree.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object but got %v", tree)
+		}
+
+		known := fieldNames(value.Type(), tag)
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !known[key] {
+				return fmt.Errorf("unknown key %v", key)
+			}
+		}
+
+		return decodeFields(fields, value, tag)
+	case reflect.Slice:
+		items, ok := tree.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected a list but got %v", tree)
+		}
+
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeConfig(item, slice.Index(i), tag); err != nil {
+				return err
+			}
+		}
+
+		value.Set(slice)
+	case reflect.Map:
+		items, ok := tree.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object but got %v", tree)
+		}
+
+		m := reflect.MakeMapWithSize(value.Type(), len(items))
+		for key, item := range items {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			if err := decodeConfig(item, elem, tag); err != nil {
+				return fmt.Errorf("%v: %v", key, err)
+			}
+
+			m.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+
+		value.Set(m)
+	case reflect.Int, reflect.Float64:
+		switch n := tree.(type) {
+		case int:
+			return setField(value, strconv.Itoa(n))
+		case float64:
+			return setField(value, strconv.FormatFloat(n, 'f', -1, 64))
+		default:
+			return fmt.Errorf("expected a number but got %v", tree)
+		}
+	default:
+		return setField(value, fmt.Sprint(tree))
+	}
+
+	return nil
+}
+
+// decodeFields decodes the fields of the struct from the object, the fields
+// of embedded structs are decoded from the same object
+func decodeFields(fields map[string]interface{}, value reflect.Value, tag string) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Anonymous {
+			if err := decodeFields(fields, value.Field(i), tag); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if child, ok := fields[name]; ok && name != "" {
+			if err := decodeConfig(child, value.Field(i), tag); err != nil {
+				return fmt.Errorf("%v: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldNames returns the names of the fields of the struct which can be
+// decoded, including the fields of embedded structs
+func fieldNames(t reflect.Type, tag string) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			for name := range fieldNames(field.Type, tag) {
+				names[name] = true
+			}
+
+			continue
+		}
+
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+// LoadConfigEnv sets the fields of the FileConfig from the environment
+// variables with the given prefix, fields without a variable are not changed.
+// Lists such as ENDPOINTS are comma separated.
+// ULTRACLIENT_TIMEOUT=50ms ULTRACLIENT_ENDPOINTS=server1:8080,server2:8080
+// err := ultraclient.LoadConfigEnv("ULTRACLIENT_", &config)
+func LoadConfigEnv(prefix string, config *FileConfig) error {
+	return loadEnv(prefix, reflect.ValueOf(config).Elem())
+}
+
+func loadEnv(prefix string, value reflect.Value) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		structField := value.Type().Field(i)
+
+		tag, ok := structField.Tag.Lookup("env")
+		if !ok && !structField.Anonymous {
+			continue
+		}
+
+		name := prefix + tag
+
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(name, field); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(field, env); err != nil {
+			return fmt.Errorf("unable to load config from %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// setField sets the field from its text form
+func setField(field reflect.Value, text string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		var d Duration
+		if err := d.UnmarshalText([]byte(text)); err != nil {
+			return err
+		}
+
+		field.SetInt(int64(d))
+		return nil
+	case url.URL:
+		u, err := parseEndpoint(text)
+		if err != nil {
+			return err
+		}
+
+		field.Set(reflect.ValueOf(u))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Int:
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unable to set %v from %q", field.Type(), text)
+	}
+
+	return nil
+}
+
+// Options returns the options for New which apply the settings given in the
+// FileConfig
+func (f FileConfig) Options() ([]Option, error) {
+	strategy, err := strategyByName(f.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff, err := backoffByName(f.Backoff)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Option{func(o *options) {
+		o.config = f.Config
+
+		if strategy != nil {
+			o.loadbalancingStrategy = strategy
+		}
+
+		if backoff != nil {
+			o.backoffStrategy = backoff
+		}
+	}}, nil
+}
+
+// NewClientFromConfigFile creates a new client from a JSON or YAML file,
+// environment variables prefixed with ULTRACLIENT_ override the settings in
+// the file.  Options are applied after the file and can be used to register
+// stats.
+// client, err := ultraclient.NewClientFromConfigFile("client.yaml", ultraclient.WithStats(...))
+func NewClientFromConfigFile(path string, opts ...Option) (Client, error) {
+	fileOpts, err := loadFileOptions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(append(fileOpts, opts...)...)
+}
+
+// loadFileOptions loads the options from the file and the environment
+func loadFileOptions(path string) ([]Option, error) {
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := LoadConfigEnv("ULTRACLIENT_", &config); err != nil {
+		return nil, err
+	}
+
+	return config.Options()
+}
+
+// parseEndpoint parses an endpoint written as a url or as host:port
+func parseEndpoint(endpoint string) (url.URL, error) {
+	if !strings.Contains(endpoint, "://") {
+		return url.URL{Host: endpoint}, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return url.URL{}, fmt.Errorf("invalid endpoint %v: %v", endpoint, err)
+	}
+
+	return *u, nil
+}
+
+func strategyByName(name string) (LoadbalancingStrategy, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "roundrobin", "round_robin":
+		return &RoundRobinStrategy{}, nil
+	case "random":
+		return &RandomStrategy{}, nil
+	case "weighted":
+		return &WeightedRoundRobinStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown loadbalancing strategy %v, must be roundrobin, random or weighted", name)
+	}
+}
+
+func backoffByName(name string) (BackoffStrategy, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "exponential":
+		return &ExponentialBackoff{}, nil
+	default:
+		return nil, fmt.Errorf("unknown backoff strategy %v, must be exponential", name)
+	}
+}
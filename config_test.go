@@ -0,0 +1,178 @@
+package ultraclient
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlConfig = `
+timeout: 50ms
+max_concurrent_requests: 500
+error_percent_threshold: 25
+retries: 3
+retry_delay: 10ms
+endpoints:
+  - server1:8080
+  - http://server2:8080
+strategy: random
+backoff: exponential
+statsd:
+  prefix: application.client
+  tags: ["env:production"]
+`
+
+const jsonConfig = `{
+  "timeout": "50ms",
+  "max_concurrent_requests": 500,
+  "endpoints": ["server1:8080"],
+  "statsd": {"prefix": "application.client"}
+}`
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestLoadConfigFileDecodesYAML(t *testing.T) {
+	config, err := LoadConfigFile(writeConfigFile(t, "client.yaml", yamlConfig))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, config.Timeout)
+	assert.Equal(t, 500, config.MaxConcurrentRequests)
+	assert.Equal(t, 10*time.Millisecond, config.RetryDelay)
+	assert.Equal(t, []url.URL{{Host: "server1:8080"}, {Scheme: "http", Host: "server2:8080"}}, config.Endpoints)
+	assert.Equal(t, "random", config.Strategy)
+	assert.Equal(t, "application.client", config.StatsD.Prefix)
+	assert.Equal(t, []string{"env:production"}, config.StatsD.Tags)
+}
+
+func TestLoadConfigFileDecodesJSON(t *testing.T) {
+	config, err := LoadConfigFile(writeConfigFile(t, "client.json", jsonConfig))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, config.Timeout)
+	assert.Equal(t, 500, config.MaxConcurrentRequests)
+	assert.Equal(t, []url.URL{{Host: "server1:8080"}}, config.Endpoints)
+}
+
+func TestLoadConfigFileKeepsDefaults(t *testing.T) {
+	config, err := LoadConfigFile(writeConfigFile(t, "client.yaml", "timeout: 50ms"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 20, config.DefaultVolumeThreshold)
+	assert.Equal(t, 100*time.Millisecond, config.RetryDelay)
+}
+
+func TestLoadConfigFileDecodesNestedSettings(t *testing.T) {
+	config, err := LoadConfigFile(writeConfigFile(t, "client.yaml", `
+cache:
+  size: 100
+  ttl: 1m
+rate_limit:
+  rate: 2.5
+  wait: true
+adaptive_concurrency:
+  initial_limit: 20
+  latency_threshold: 250ms
+bulkheads:
+  reporting:
+    max_concurrent_requests: 2
+    queue_timeout: 10ms
+`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, Cache{Size: 100, TTL: time.Minute}, config.Cache)
+	assert.Equal(t, RateLimit{Rate: 2.5, Wait: true}, config.RateLimit)
+	assert.Equal(t, AdaptiveConcurrency{InitialLimit: 20, LatencyThreshold: 250 * time.Millisecond}, config.AdaptiveConcurrency)
+	assert.Equal(t, map[string]Bulkhead{"reporting": {MaxConcurrentRequests: 2, QueueTimeout: 10 * time.Millisecond}}, config.Bulkheads)
+}
+
+func TestLoadConfigFileReturnsErrorForInvalidDuration(t *testing.T) {
+	_, err := LoadConfigFile(writeConfigFile(t, "client.yaml", "timeout: fifty"))
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigFileReturnsErrorForUnknownKey(t *testing.T) {
+	path := writeConfigFile(t, "client.yaml", "timeout: 50ms\nstatsd:\n  prefx: application.client")
+
+	_, err := LoadConfigFile(path)
+
+	assert.EqualError(t, err, "unable to load config from "+path+": statsd: unknown key prefx")
+}
+
+func TestLoadConfigFileDecodesEmptyKeyAsZeroValue(t *testing.T) {
+	config, err := LoadConfigFile(writeConfigFile(t, "client.yaml", "timeout: 50ms\nstatsd:\nendpoints:"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, StatsD{}, config.StatsD)
+	assert.Nil(t, config.Endpoints)
+}
+
+func TestLoadConfigFileReturnsErrorForUnknownFormat(t *testing.T) {
+	_, err := LoadConfigFile(writeConfigFile(t, "client.toml", ""))
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigEnvOverridesFields(t *testing.T) {
+	t.Setenv("TEST_TIMEOUT", "2s")
+	t.Setenv("TEST_RETRIES", "4")
+	t.Setenv("TEST_ENDPOINTS", "server1:8080, server2:8080")
+	t.Setenv("TEST_STATSD_PREFIX", "env.client")
+
+	t.Setenv("TEST_LOAD_SHEDDING_ENABLED", "true")
+
+	config := FileConfig{Config: Config{MaxConcurrentRequests: 500}}
+	err := LoadConfigEnv("TEST_", &config)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, config.Timeout)
+	assert.Equal(t, 4, config.Retries)
+	assert.Equal(t, 500, config.MaxConcurrentRequests)
+	assert.Equal(t, []url.URL{{Host: "server1:8080"}, {Host: "server2:8080"}}, config.Endpoints)
+	assert.Equal(t, "env.client", config.StatsD.Prefix)
+	assert.True(t, config.LoadShedding.Enabled)
+}
+
+func TestLoadConfigEnvReturnsErrorForInvalidNumber(t *testing.T) {
+	t.Setenv("TEST_RETRIES", "many")
+
+	err := LoadConfigEnv("TEST_", &FileConfig{})
+
+	assert.NotNil(t, err)
+}
+
+func TestNewClientFromConfigFile(t *testing.T) {
+	c, err := NewClientFromConfigFile(writeConfigFile(t, "client.yaml", yamlConfig))
+
+	assert.Nil(t, err)
+
+	impl := c.(*ClientImpl)
+	assert.Equal(t, 50*time.Millisecond, impl.config.Timeout)
+	assert.Equal(t, 500, impl.config.MaxConcurrentRequests)
+	assert.Equal(t, 25, impl.config.ErrorPercentThreshold)
+	assert.Equal(t, 20, impl.config.DefaultVolumeThreshold)
+	assert.Equal(t, 3, impl.config.Retries)
+	assert.Equal(t, []url.URL{{Host: "server1:8080"}, {Scheme: "http", Host: "server2:8080"}}, impl.config.Endpoints)
+	assert.IsType(t, &RandomStrategy{}, impl.loadbalancingStrategy)
+}
+
+func TestNewClientFromConfigFileReturnsErrorForUnknownStrategy(t *testing.T) {
+	_, err := NewClientFromConfigFile(writeConfigFile(t, "client.yaml", "strategy: leastconn\nendpoints: [server1:8080]"))
+
+	assert.NotNil(t, err)
+}
+
+func TestNewClientFromConfigFileValidatesConfig(t *testing.T) {
+	_, err := NewClientFromConfigFile(writeConfigFile(t, "client.yaml", "timeout: 50ms"))
+
+	assert.IsType(t, ConfigError{}, err)
+}
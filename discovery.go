@@ -0,0 +1,106 @@
+package ultraclient
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Discoverer is implemented by service discovery providers which find the
+// endpoints for a client
+type Discoverer interface {
+	// Watch returns a channel which receives the endpoints each time they
+	// change with the weight, zone and labels of each endpoint, an empty set
+	// is sent when discovery fails.  The channel is closed when the context is
+	// done.
+	Watch(ctx context.Context) <-chan []Endpoint
+}
+
+// maxDebounceWaits bounds how long changes which keep arriving are held back,
+// pending endpoints are applied at most this many debounce durations after
+// the first change
+const maxDebounceWaits = 5
+
+// Discover subscribes the client to the discoverer, the endpoints of the
+// client and its clones are updated with UpdateEndpoints once the discovered
+// endpoints have not changed for the debounce duration, endpoints which keep
+// changing are applied five debounce durations after the first change.  When
+// discovery fails or returns no endpoints the last good endpoints are kept.
+// The client is subscribed until the context is done, while it is subscribed
+// Reload does not change the endpoints.
+// client.Discover(ctx, discoverer, 5*time.Second)
+func (c *ClientImpl) Discover(ctx context.Context, discoverer Discoverer, debounce time.Duration) {
+	updates := discoverer.Watch(ctx)
+
+	c.updateSettings(func(settings *clientSettings) {
+		settings.discoverers++
+	})
+
+	go func() {
+		defer c.updateSettings(func(settings *clientSettings) {
+			settings.discoverers--
+		})
+
+		var pending []Endpoint
+		var debounceTimer <-chan time.Time
+		var deadline time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case endpoints, ok := <-updates:
+				if !ok {
+					c.applyDiscovered(pending)
+					return
+				}
+
+				if len(endpoints) == 0 {
+					c.incrementStats(nil, StatsDiscoveryFailed)
+					continue
+				}
+
+				pending = endpoints
+				if debounce <= 0 {
+					c.applyDiscovered(pending)
+					pending = nil
+					continue
+				}
+
+				if debounceTimer == nil {
+					deadline = time.Now().Add(maxDebounceWaits * debounce)
+				}
+
+				wait := debounce
+				if remaining := time.Until(deadline); remaining < wait {
+					wait = remaining
+				}
+
+				debounceTimer = time.After(wait)
+			case <-debounceTimer:
+				c.applyDiscovered(pending)
+				pending = nil
+				debounceTimer = nil
+			}
+		}
+	}()
+}
+
+// applyDiscovered updates the endpoints when they or their metadata differ
+// from the current endpoints
+func (c *ClientImpl) applyDiscovered(endpoints []Endpoint) {
+	if len(endpoints) == 0 {
+		return
+	}
+
+	c.gaugeStats(nil, float64(len(endpoints)), StatsDiscoveryEndpoints)
+
+	current := c.settings()
+	urls, metadata := splitEndpoints(endpoints)
+	if reflect.DeepEqual(current.config.Endpoints, urls) && reflect.DeepEqual(current.metadata, metadata) {
+		return
+	}
+
+	c.updateEndpoints(urls, metadata)
+	c.incrementStats(nil, StatsDiscoveryUpdate)
+}
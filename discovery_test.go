@@ -0,0 +1,132 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeDiscoverer struct {
+	updates chan []Endpoint
+}
+
+func (f *fakeDiscoverer) Watch(ctx context.Context) <-chan []Endpoint {
+	return f.updates
+}
+
+// discoveredEndpoints returns the urls as discovered endpoints with a weight
+// of 1
+func discoveredEndpoints(urls []url.URL) []Endpoint {
+	endpoints := make([]Endpoint, len(urls))
+	for i, u := range urls {
+		endpoints[i] = Endpoint{URL: u, Metadata: EndpointMetadata{Weight: 1}}
+	}
+
+	return endpoints
+}
+
+func setupDiscovery(t *testing.T, debounce time.Duration) (*ClientImpl, *MockGaugeStats, *fakeDiscoverer) {
+	c, err := New(WithConfig(validConfig()))
+	assert.Nil(t, err)
+
+	stats := &MockGaugeStats{}
+	stats.On("Timing", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Increment", mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	c.RegisterStats(stats)
+
+	discoverer := &fakeDiscoverer{updates: make(chan []Endpoint)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	c.Discover(ctx, discoverer, debounce)
+
+	return c.(*ClientImpl), stats, discoverer
+}
+
+// waitForEndpoints waits for the client to be given the endpoints
+func waitForEndpoints(c *ClientImpl, endpoints []url.URL) {
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		if assert.ObjectsAreEqual(endpoints, c.endpoints()) {
+			return
+		}
+
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func TestDiscoverUpdatesEndpointsOfClientAndClones(t *testing.T) {
+	c, stats, discoverer := setupDiscovery(t, 0)
+	clone := c.Clone().(*ClientImpl)
+
+	discovered := []url.URL{{Host: "discovered:8080"}}
+	discoverer.updates <- discoveredEndpoints(discovered)
+	waitForEndpoints(c, discovered)
+
+	assert.Equal(t, discovered, c.endpoints())
+	assert.Equal(t, discovered, clone.endpoints())
+	stats.AssertCalled(t, "Increment", "myapp.discoveryupdate", []string(nil), mock.Anything)
+	stats.AssertCalled(t, "Gauge", "myapp.discoveryendpoints", []string(nil), float64(1), mock.Anything)
+}
+
+func TestDiscoverKeepsLastGoodEndpointsWhenDiscoveryFails(t *testing.T) {
+	c, stats, discoverer := setupDiscovery(t, 0)
+
+	discoverer.updates <- nil
+	discoverer.updates <- []Endpoint{}
+
+	// the discoverer is unbuffered so the updates above have been handled
+	// once the next update is received
+	discoverer.updates <- discoveredEndpoints(reloadURLs)
+
+	assert.Equal(t, reloadURLs, c.endpoints())
+	stats.AssertNumberOfCalls(t, "Increment", 2)
+	stats.AssertCalled(t, "Increment", "myapp.discoveryfailed", []string(nil), mock.Anything)
+}
+
+func TestDiscoverDebouncesUpdates(t *testing.T) {
+	c, stats, discoverer := setupDiscovery(t, 50*time.Millisecond)
+
+	first := []url.URL{{Host: "first:8080"}}
+	second := []url.URL{{Host: "second:8080"}}
+
+	discoverer.updates <- discoveredEndpoints(first)
+	discoverer.updates <- discoveredEndpoints(second)
+	assert.Equal(t, reloadURLs, c.endpoints())
+
+	waitForEndpoints(c, second)
+
+	assert.Equal(t, second, c.endpoints())
+	stats.AssertNumberOfCalls(t, "Increment", 1)
+}
+
+func TestDiscoverAppliesPendingEndpointsWhenWatchEnds(t *testing.T) {
+	c, _, discoverer := setupDiscovery(t, 1*time.Minute)
+
+	discovered := []url.URL{{Host: "discovered:8080"}}
+	discoverer.updates <- discoveredEndpoints(discovered)
+	close(discoverer.updates)
+
+	waitForEndpoints(c, discovered)
+
+	assert.Equal(t, discovered, c.endpoints())
+}
+
+func TestDiscoverAppliesEndpointsWhichKeepChanging(t *testing.T) {
+	c, _, discoverer := setupDiscovery(t, 20*time.Millisecond)
+
+	last := []url.URL{{Host: "changing:8080"}}
+	stop := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(stop) && !assert.ObjectsAreEqual(last, c.endpoints()) {
+		discoverer.updates <- discoveredEndpoints(last)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, last, c.endpoints())
+}
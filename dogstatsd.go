@@ -43,3 +43,27 @@ func (d *DogStatsD) Timing(name string, tags []string, duration time.Duration, r
 		fmt.Println(err)
 	}
 }
+
+// Gauge sends the current value of a metric to statsd
+func (d *DogStatsD) Gauge(name string, tags []string, value float64, rate float64) {
+	err := d.client.Gauge(name, value, tags, rate)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Histogram sends a value to the distribution of a metric to statsd
+func (d *DogStatsD) Histogram(name string, tags []string, value float64, rate float64) {
+	err := d.client.Histogram(name, value, tags, rate)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Count sends a statsd message to add the delta to a bucket to datadog
+func (d *DogStatsD) Count(name string, tags []string, delta int64, rate float64) {
+	err := d.client.Count(name, delta, tags, rate)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -1,8 +1,11 @@
 package ultraclient
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"reflect"
+	"time"
 )
 
 const (
@@ -18,6 +21,32 @@ const (
 	// client returns a general unhandled error.
 	ErrorGeneral = "general error"
 
+	// ErrorMaxConcurrency is a constant to be used for an error message when
+	// the client has too many requests in flight.
+	ErrorMaxConcurrency = "max concurrency"
+
+	// ErrorQuorumNotReached is a constant to be used for an error message when
+	// work performed against multiple endpoints did not succeed for enough
+	// endpoints.
+	ErrorQuorumNotReached = "quorum not reached"
+
+	// ErrorRateLimited is a constant to be used for an error message when the
+	// request is rejected by the rate limit.
+	ErrorRateLimited = "rate limited"
+
+	// ErrorConcurrencyLimited is a constant to be used for an error message
+	// when the request is rejected by the adaptive concurrency limit.
+	ErrorConcurrencyLimited = "concurrency limited"
+
+	// ErrorLoadShed is a constant to be used for an error message when the
+	// request is rejected because the client is under too much load for the
+	// priority of the request.
+	ErrorLoadShed = "load shed"
+
+	// ErrorBulkheadFull is a constant to be used for an error message when the
+	// bulkhead for the request has no free capacity.
+	ErrorBulkheadFull = "bulkhead full"
+
 	// ErrorUnableToCompleteRequest is a constant to be used for an error message
 	// when the client is unable to complete the request.
 	ErrorUnableToCompleteRequest = "unable to complete request"
@@ -30,9 +59,134 @@ type ClientError struct {
 
 	// URL is the endpoint from which the message orginated
 	URL url.URL
+
+	// Err is the error returned from the work function, nil when the error
+	// was raised by the client such as a timeout or an open circuit
+	Err error
 }
 
 // Error implements the error interface
 func (s ClientError) Error() string {
 	return fmt.Sprintf("%v for url: %v", s.Message, s.URL.String())
 }
+
+// Unwrap returns the error returned from the work function
+func (s ClientError) Unwrap() error {
+	return s.Err
+}
+
+// TypeMismatchError is returned from the typed DoShared and DoCached when the
+// value shared or cached for the key is not of the type requested, for
+// example when the same key is used with different types.
+type TypeMismatchError struct {
+	// Key is the key of the value
+	Key string
+
+	// Value is the value shared or cached for the key
+	Value interface{}
+
+	// Type is the type requested
+	Type reflect.Type
+}
+
+// Error implements the error interface
+func (t TypeMismatchError) Error() string {
+	return fmt.Sprintf("value for key %v is a %T not a %v", t.Key, t.Value, t.Type)
+}
+
+// RetryAfterError is returned from a WorkFunc to tell the client how long to
+// wait before the next attempt, for example when an upstream responds with
+// a 429 and a Retry-After header.
+type RetryAfterError struct {
+	// Err is the original error
+	Err error
+
+	// Delay is the time to wait before the next attempt
+	Delay time.Duration
+}
+
+// Error implements the error interface
+func (r RetryAfterError) Error() string {
+	return r.Err.Error()
+}
+
+// Unwrap returns the original error
+func (r RetryAfterError) Unwrap() error {
+	return r.Err
+}
+
+// RetryAfter wraps the given error with a hint for the delay before the next
+// attempt, the delay replaces the value from the backoff strategy.
+// return ultraclient.RetryAfter(err, 2*time.Second)
+func RetryAfter(err error, delay time.Duration) error {
+	return RetryAfterError{Err: err, Delay: delay}
+}
+
+// NonRetryableError is returned from a WorkFunc to stop the client from
+// making any further attempts, for example when the request is invalid.
+type NonRetryableError struct {
+	// Err is the original error
+	Err error
+}
+
+// Error implements the error interface
+func (n NonRetryableError) Error() string {
+	return n.Err.Error()
+}
+
+// Unwrap returns the original error
+func (n NonRetryableError) Unwrap() error {
+	return n.Err
+}
+
+// NonRetryable wraps the given error so that the client returns it
+// immediately rather than retrying the work.
+// return ultraclient.NonRetryable(err)
+func NonRetryable(err error) error {
+	return NonRetryableError{Err: err}
+}
+
+// BadRequestError is returned from a WorkFunc when the upstream rejected the
+// request because of a problem with the request rather than the endpoint,
+// for example an invalid argument or a missing resource.  The error is returned
+// without retrying and does not count towards opening the circuit.
+type BadRequestError struct {
+	// Err is the original error
+	Err error
+}
+
+// Error implements the error interface
+func (b BadRequestError) Error() string {
+	return b.Err.Error()
+}
+
+// Unwrap returns the original error
+func (b BadRequestError) Unwrap() error {
+	return b.Err
+}
+
+// BadRequest wraps the given error so that the client returns it without
+// retrying and without counting it as a failure of the endpoint.
+// return ultraclient.BadRequest(err)
+func BadRequest(err error) error {
+	return BadRequestError{Err: err}
+}
+
+func isRetryable(err error) bool {
+	var nonRetryable NonRetryableError
+	return !errors.As(err, &nonRetryable) && !isBadRequest(err)
+}
+
+func isBadRequest(err error) bool {
+	var badRequest BadRequestError
+	return errors.As(err, &badRequest)
+}
+
+func retryAfterHint(err error) (time.Duration, bool) {
+	var hint RetryAfterError
+	if errors.As(err, &hint) {
+		return hint.Delay, true
+	}
+
+	return 0, false
+}
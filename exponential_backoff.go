@@ -1,6 +1,7 @@
 package ultraclient
 
 import (
+	"sync"
 	"time"
 
 	"github.com/eapache/go-resiliency/retrier"
@@ -9,14 +10,23 @@ import (
 // ExponentialBackoff is a backoffStrategy which implements an exponential
 // retry policy
 type ExponentialBackoff struct {
-	cache []time.Duration
+	sync.Mutex
+	cache   []time.Duration
+	retries int
+	delay   time.Duration
 }
 
 // Create creates a new ExponentialBackoff timings with the given retries and
-// iniital delay
+// iniital delay, the timings are cached until they are created with different
+// retries or delay
 func (e *ExponentialBackoff) Create(retries int, delay time.Duration) []time.Duration {
-	if e.cache == nil {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.cache == nil || e.retries != retries || e.delay != delay {
 		e.cache = retrier.ExponentialBackoff(retries, delay)
+		e.retries = retries
+		e.delay = delay
 	}
 
 	return e.cache
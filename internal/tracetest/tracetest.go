@@ -0,0 +1,76 @@
+// Package tracetest provides a Tracer which records how spans are linked, it
+// is used by the tests of the adapters to check the trace of a call reaches
+// the request sent downstream
+package tracetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type spanKey struct{}
+
+// Span records the span it was started as a child of
+type Span struct {
+	Name   string
+	Parent *Span
+}
+
+// SetAttributes does nothing
+func (s *Span) SetAttributes(attributes ...ultraclient.Attribute) {}
+
+// RecordError does nothing
+func (s *Span) RecordError(err error) {}
+
+// End does nothing
+func (s *Span) End() {}
+
+// Tracer records every span started
+type Tracer struct {
+	sync.Mutex
+	spans map[string]*Span
+}
+
+// NewTracer creates a Tracer which has not started any spans
+func NewTracer() *Tracer {
+	return &Tracer{spans: map[string]*Span{}}
+}
+
+// Start starts a span which is a child of the span in the context
+func (l *Tracer) Start(
+	ctx context.Context,
+	name string,
+	attributes ...ultraclient.Attribute) (context.Context, ultraclient.Span) {
+
+	l.Lock()
+	defer l.Unlock()
+
+	parent, _ := ctx.Value(spanKey{}).(*Span)
+	span := &Span{Name: name, Parent: parent}
+	l.spans[name] = span
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Span returns the last span started with the name
+func (l *Tracer) Span(name string) *Span {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.spans[name]
+}
+
+// AssertLinked asserts the context passed downstream belongs to the attempt
+// which is a child of the call, which is a child of the span named caller
+func AssertLinked(t *testing.T, tracer *Tracer, downstream context.Context) {
+	call := tracer.Span(ultraclient.SpanDo)
+	attempt := tracer.Span(ultraclient.SpanAttempt)
+
+	assert.Equal(t, tracer.Span("caller"), call.Parent)
+	assert.Equal(t, call, attempt.Parent)
+	assert.Equal(t, attempt, downstream.Value(spanKey{}))
+}
@@ -1,7 +1,9 @@
 package ultraclient
 
 import (
+	"context"
 	"net/url"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -23,6 +25,108 @@ func (m *MockClient) Do(work WorkFunc) error {
 	return args.Error(0)
 }
 
+// DoContext is the mock execution of the DoContext method
+// mockClient.On("DoContext", mock.Anything, mock.Anything).Return(error, url)
+func (m *MockClient) DoContext(ctx context.Context, work ContextWorkFunc) error {
+	args := m.Called(ctx, work)
+
+	if len(args) > 1 {
+		return work(ctx, args.Get(1).(url.URL))
+	}
+
+	return args.Error(0)
+}
+
+// DoWithFallback is the mock execution of the DoWithFallback method, when
+// the mock returns an error the fallback is called with it
+func (m *MockClient) DoWithFallback(work WorkFunc, fallback FallbackFunc) error {
+	args := m.Called(work, fallback)
+
+	if len(args) > 1 {
+		return work(args.Get(1).(url.URL))
+	}
+
+	if err := args.Error(0); err != nil {
+		return fallback(err)
+	}
+
+	return nil
+}
+
+// DoContextWithFallback is the mock execution of the DoContextWithFallback
+// method, when the mock returns an error the fallback is called with it
+func (m *MockClient) DoContextWithFallback(ctx context.Context, work ContextWorkFunc, fallback FallbackFunc) error {
+	args := m.Called(ctx, work, fallback)
+
+	if len(args) > 1 {
+		return work(ctx, args.Get(1).(url.URL))
+	}
+
+	if err := args.Error(0); err != nil {
+		return fallback(err)
+	}
+
+	return nil
+}
+
+// DoAsync is the mock execution of the DoAsync method, the result of the
+// work is sent to the returned channel
+func (m *MockClient) DoAsync(work WorkFunc) <-chan error {
+	args := m.Called(work)
+	errChan := make(chan error, 1)
+
+	if len(args) > 1 {
+		errChan <- work(args.Get(1).(url.URL))
+	} else {
+		errChan <- args.Error(0)
+	}
+
+	close(errChan)
+	return errChan
+}
+
+// DoAll is the mock execution of the DoAll method
+// mockClient.On("DoAll", mock.Anything, mock.Anything).Return(results, error)
+func (m *MockClient) DoAll(ctx context.Context, work ContextWorkFunc) ([]EndpointResult, error) {
+	args := m.Called(ctx, work)
+
+	results, _ := args.Get(0).([]EndpointResult)
+	return results, args.Error(1)
+}
+
+// DoQuorum is the mock execution of the DoQuorum method
+// mockClient.On("DoQuorum", mock.Anything, 2, mock.Anything).Return(results, error)
+func (m *MockClient) DoQuorum(ctx context.Context, n int, work ContextWorkFunc) ([]EndpointResult, error) {
+	args := m.Called(ctx, n, work)
+
+	results, _ := args.Get(0).([]EndpointResult)
+	return results, args.Error(1)
+}
+
+// DoShared is the mock execution of the DoShared method
+// mockClient.On("DoShared", mock.Anything, "key", mock.Anything).Return(value, error, url)
+func (m *MockClient) DoShared(ctx context.Context, key string, work SharedWorkFunc) (interface{}, error) {
+	args := m.Called(ctx, key, work)
+
+	if len(args) > 2 {
+		return work(ctx, args.Get(2).(url.URL))
+	}
+
+	return args.Get(0), args.Error(1)
+}
+
+// DoCached is the mock execution of the DoCached method
+// mockClient.On("DoCached", mock.Anything, "key", mock.Anything).Return(value, error, url)
+func (m *MockClient) DoCached(ctx context.Context, key string, work SharedWorkFunc) (interface{}, error) {
+	args := m.Called(ctx, key, work)
+
+	if len(args) > 2 {
+		return work(ctx, args.Get(2).(url.URL))
+	}
+
+	return args.Get(0), args.Error(1)
+}
+
 // UpdateEndpoints is a mock execution of the interface method
 func (m *MockClient) UpdateEndpoints(endpoints []url.URL) {
 	m.Called(endpoints)
@@ -34,7 +138,33 @@ func (m *MockClient) Clone() Client {
 	return m
 }
 
+// Discover is the mock execution of the Discover method
+func (m *MockClient) Discover(ctx context.Context, discoverer Discoverer, debounce time.Duration) {
+	m.Called(ctx, discoverer, debounce)
+}
+
+// Endpoints is the mock execution of the Endpoints method
+// mockClient.On("Endpoints").Return([]url.URL{})
+func (m *MockClient) Endpoints() []url.URL {
+	args := m.Called()
+
+	return args.Get(0).([]url.URL)
+}
+
+// Reload is the mock execution of the Reload method
+// mockClient.On("Reload", mock.Anything).Return(error)
+func (m *MockClient) Reload(config Config) error {
+	args := m.Called(config)
+
+	return args.Error(0)
+}
+
 // RegisterStats is the mock execution of the RegisterStats method
 func (m *MockClient) RegisterStats(stats Stats) {
 	m.Called(stats)
 }
+
+// RegisterTracer is the mock execution of the RegisterTracer method
+func (m *MockClient) RegisterTracer(tracer Tracer) {
+	m.Called(tracer)
+}
@@ -0,0 +1,314 @@
+package ultraclient
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Option configures a client created with New
+type Option func(o *options)
+
+// options holds everything needed to create a client
+type options struct {
+	config                Config
+	loadbalancingStrategy LoadbalancingStrategy
+	backoffStrategy       BackoffStrategy
+	stats                 []Stats
+	tracer                Tracer
+}
+
+// defaultOptions are the options used by New before any Option is applied,
+// the circuit breaker defaults match those of hystrix
+func defaultOptions() *options {
+	return &options{
+		config: Config{
+			Timeout:                1 * time.Second,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  50,
+			DefaultVolumeThreshold: 20,
+			RetryDelay:             100 * time.Millisecond,
+		},
+		loadbalancingStrategy: &RoundRobinStrategy{},
+		backoffStrategy:       &ExponentialBackoff{},
+	}
+}
+
+// WithConfig replaces the whole configuration of the client, options applied
+// after it change the given configuration
+func WithConfig(config Config) Option {
+	return func(o *options) {
+		o.config = config
+	}
+}
+
+// WithEndpoints sets the endpoints passed to the loadbalancing strategy
+func WithEndpoints(endpoints ...url.URL) Option {
+	return func(o *options) {
+		o.config.Endpoints = endpoints
+	}
+}
+
+// WithStrategy sets the loadbalancing strategy, the default is
+// RoundRobinStrategy
+func WithStrategy(strategy LoadbalancingStrategy) Option {
+	return func(o *options) {
+		o.loadbalancingStrategy = strategy
+	}
+}
+
+// WithBackoff sets the backoff strategy used between retries, the default is
+// ExponentialBackoff
+func WithBackoff(strategy BackoffStrategy) Option {
+	return func(o *options) {
+		o.backoffStrategy = strategy
+	}
+}
+
+// WithRetries sets the number of retries and the delay passed to the backoff
+// strategy
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(o *options) {
+		o.config.Retries = retries
+		o.config.RetryDelay = delay
+	}
+}
+
+// WithTimeout sets the length of time to wait before the work times out
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.config.Timeout = timeout
+	}
+}
+
+// WithBreaker sets the circuit breaker settings for each endpoint
+// maxConcurrentRequests is the maximum number of requests in flight
+// errorPercentThreshold is the percentage of requests which fail before the
+// circuit opens
+// volumeThreshold is the number of requests required before the circuit can
+// open
+func WithBreaker(maxConcurrentRequests, errorPercentThreshold, volumeThreshold int) Option {
+	return func(o *options) {
+		o.config.MaxConcurrentRequests = maxConcurrentRequests
+		o.config.ErrorPercentThreshold = errorPercentThreshold
+		o.config.DefaultVolumeThreshold = volumeThreshold
+	}
+}
+
+// WithStats registers the stats with the client and sets the prefix and tags
+// for every metric
+func WithStats(prefix string, tags []string, stats ...Stats) Option {
+	return func(o *options) {
+		o.config.StatsD = StatsD{Prefix: prefix, Tags: tags}
+		o.stats = append(o.stats, stats...)
+	}
+}
+
+// WithTracer records a span for each call to the client and each attempt
+// with the tracer
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// New creates a new instance of the loadbalancing client from the options,
+// an error is returned when the resulting configuration is not valid
+// client, err := ultraclient.New(
+//   ultraclient.WithEndpoints(url.URL{Host: "server1:8080"}, url.URL{Host: "server2:8080"}),
+//   ultraclient.WithTimeout(50*time.Millisecond),
+//   ultraclient.WithStats("application.client", nil, stats),
+// )
+func New(opts ...Option) (Client, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.loadbalancingStrategy == nil || o.backoffStrategy == nil {
+		return nil, ConfigError{Problems: []string{"strategy and backoff must not be nil"}}
+	}
+
+	if err := validateConfig(o.config); err != nil {
+		return nil, err
+	}
+
+	return newClient(o), nil
+}
+
+// ConfigError is returned when the configuration of a client is not valid
+type ConfigError struct {
+	// Problems describes each invalid setting
+	Problems []string
+}
+
+// Error returns every problem with the configuration
+func (c ConfigError) Error() string {
+	return "invalid client config: " + strings.Join(c.Problems, ", ")
+}
+
+// validateConfig returns a ConfigError describing every invalid setting
+func validateConfig(config Config) error {
+	var problems []string
+	invalid := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if config.Timeout <= 0 {
+		invalid("Timeout must be greater than zero, got %v", config.Timeout)
+	}
+
+	if config.MaxConcurrentRequests < 1 {
+		invalid("MaxConcurrentRequests must be at least 1, got %v", config.MaxConcurrentRequests)
+	}
+
+	if config.ErrorPercentThreshold < 1 || config.ErrorPercentThreshold > 100 {
+		invalid("ErrorPercentThreshold must be between 1 and 100, got %v", config.ErrorPercentThreshold)
+	}
+
+	if config.DefaultVolumeThreshold < 0 {
+		invalid("DefaultVolumeThreshold must not be negative, got %v", config.DefaultVolumeThreshold)
+	}
+
+	if config.Retries < 0 {
+		invalid("Retries must not be negative, got %v", config.Retries)
+	}
+
+	if config.RetryDelay < 0 {
+		invalid("RetryDelay must not be negative, got %v", config.RetryDelay)
+	}
+
+	if config.MaxRetryAfter < 0 {
+		invalid("MaxRetryAfter must not be negative, got %v", config.MaxRetryAfter)
+	}
+
+	if len(config.Endpoints) == 0 {
+		invalid("at least one endpoint is required")
+	}
+
+	for _, endpoint := range config.Endpoints {
+		if endpoint.Host == "" {
+			invalid("endpoint %q has no host", endpoint.String())
+		}
+	}
+
+	if config.Cache.Size < 0 {
+		invalid("Cache.Size must not be negative, got %v", config.Cache.Size)
+	}
+
+	if config.Cache.TTL < 0 {
+		invalid("Cache.TTL must not be negative, got %v", config.Cache.TTL)
+	}
+
+	if config.Cache.MaxStale < 0 {
+		invalid("Cache.MaxStale must not be negative, got %v", config.Cache.MaxStale)
+	}
+
+	validateRateLimit("RateLimit", config.RateLimit, invalid)
+	validateRateLimit("EndpointRateLimit", config.EndpointRateLimit, invalid)
+	validateAdaptiveConcurrency(config.AdaptiveConcurrency, invalid)
+	validateLoadShedding(config.LoadShedding, invalid)
+
+	names := make([]string, 0, len(config.Bulkheads))
+	for name := range config.Bulkheads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		validateBulkhead(fmt.Sprintf("Bulkheads[%q]", name), config.Bulkheads[name], invalid)
+	}
+	validateBulkhead("DefaultBulkhead", config.DefaultBulkhead, invalid)
+
+	if len(problems) > 0 {
+		return ConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+func validateRateLimit(name string, limit RateLimit, invalid func(string, ...interface{})) {
+	if limit.Rate < 0 {
+		invalid("%s.Rate must not be negative, got %v", name, limit.Rate)
+	}
+
+	if limit.Burst < 0 {
+		invalid("%s.Burst must not be negative, got %v", name, limit.Burst)
+	}
+}
+
+func validateAdaptiveConcurrency(limit AdaptiveConcurrency, invalid func(string, ...interface{})) {
+	if limit.InitialLimit < 0 {
+		invalid("AdaptiveConcurrency.InitialLimit must not be negative, got %v", limit.InitialLimit)
+	}
+
+	if limit.MinLimit < 0 {
+		invalid("AdaptiveConcurrency.MinLimit must not be negative, got %v", limit.MinLimit)
+	}
+
+	if limit.MaxLimit < 0 {
+		invalid("AdaptiveConcurrency.MaxLimit must not be negative, got %v", limit.MaxLimit)
+	}
+
+	if limit.MaxLimit > 0 && limit.MinLimit > limit.MaxLimit {
+		invalid("AdaptiveConcurrency.MinLimit must not be greater than MaxLimit, got %v and %v",
+			limit.MinLimit, limit.MaxLimit)
+	}
+
+	if limit.MaxLimit > 0 && limit.InitialLimit > limit.MaxLimit {
+		invalid("AdaptiveConcurrency.InitialLimit must not be greater than MaxLimit, got %v and %v",
+			limit.InitialLimit, limit.MaxLimit)
+	}
+
+	if limit.BackoffRatio < 0 || limit.BackoffRatio >= 1 {
+		invalid("AdaptiveConcurrency.BackoffRatio must be at least 0 and less than 1, got %v", limit.BackoffRatio)
+	}
+
+	if limit.LatencyThreshold < 0 {
+		invalid("AdaptiveConcurrency.LatencyThreshold must not be negative, got %v", limit.LatencyThreshold)
+	}
+}
+
+// validateLoadShedding checks each threshold is a load between 0 and 1 and
+// that lower priorities are shed first once the defaults are applied
+func validateLoadShedding(shedding LoadShedding, invalid func(string, ...interface{})) {
+	thresholds := []struct {
+		name     string
+		value    float64
+		fallback float64
+	}{
+		{"LowThreshold", shedding.LowThreshold, 0.5},
+		{"NormalThreshold", shedding.NormalThreshold, 0.8},
+		{"HighThreshold", shedding.HighThreshold, 0.95},
+	}
+
+	for i, threshold := range thresholds {
+		if threshold.value < 0 || threshold.value > 1 {
+			invalid("LoadShedding.%s must be between 0 and 1, got %v", threshold.name, threshold.value)
+		}
+
+		if threshold.value <= 0 {
+			thresholds[i].value = threshold.fallback
+		}
+	}
+
+	for i := 1; i < len(thresholds); i++ {
+		lower, higher := thresholds[i-1], thresholds[i]
+		if lower.value > higher.value {
+			invalid("LoadShedding.%s must not be greater than %s, got %v and %v",
+				lower.name, higher.name, lower.value, higher.value)
+		}
+	}
+}
+
+func validateBulkhead(name string, bulkhead Bulkhead, invalid func(string, ...interface{})) {
+	if bulkhead.MaxConcurrentRequests < 0 {
+		invalid("%s.MaxConcurrentRequests must not be negative, got %v", name, bulkhead.MaxConcurrentRequests)
+	}
+
+	if bulkhead.QueueTimeout < 0 {
+		invalid("%s.QueueTimeout must not be negative, got %v", name, bulkhead.QueueTimeout)
+	}
+}
@@ -0,0 +1,174 @@
+package ultraclient
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewUsesDefaults(t *testing.T) {
+	c, err := New(WithEndpoints(urls...))
+
+	assert.Nil(t, err)
+
+	impl := c.(*ClientImpl)
+	assert.Equal(t, 1*time.Second, impl.config.Timeout)
+	assert.Equal(t, 10, impl.config.MaxConcurrentRequests)
+	assert.Equal(t, 50, impl.config.ErrorPercentThreshold)
+	assert.IsType(t, &RoundRobinStrategy{}, impl.loadbalancingStrategy)
+	assert.IsType(t, &ExponentialBackoff{}, impl.backoffStrategy)
+}
+
+func TestNewAppliesOptions(t *testing.T) {
+	lb := &MockLoadbalancingStrategy{}
+	lb.On("SetEndpoints", mock.Anything)
+	lb.On("GetEndpoints").Return(urls)
+	lb.On("Length").Return(len(urls))
+
+	bs := &MockBackoffStrategy{}
+	bs.On("Create", 3, 5*time.Millisecond).Return([]time.Duration{})
+
+	stats := &MockStats{}
+
+	c, err := New(
+		WithEndpoints(urls...),
+		WithStrategy(lb),
+		WithBackoff(bs),
+		WithRetries(3, 5*time.Millisecond),
+		WithTimeout(50*time.Millisecond),
+		WithBreaker(100, 25, 10),
+		WithStats("myapp", []string{"env:production"}, stats),
+	)
+
+	assert.Nil(t, err)
+
+	impl := c.(*ClientImpl)
+	assert.Equal(t, 50*time.Millisecond, impl.config.Timeout)
+	assert.Equal(t, 100, impl.config.MaxConcurrentRequests)
+	assert.Equal(t, 25, impl.config.ErrorPercentThreshold)
+	assert.Equal(t, 10, impl.config.DefaultVolumeThreshold)
+	assert.Equal(t, "myapp", impl.config.StatsD.Prefix)
+	assert.Equal(t, []Stats{stats}, impl.statsCollection)
+	lb.AssertCalled(t, "SetEndpoints", urls)
+	bs.AssertCalled(t, "Create", 3, 5*time.Millisecond)
+}
+
+func TestNewReturnsErrorWithoutEndpoints(t *testing.T) {
+	_, err := New()
+
+	assert.Equal(t, ConfigError{Problems: []string{"at least one endpoint is required"}}, err)
+}
+
+func TestNewReturnsEveryProblemWithTheConfig(t *testing.T) {
+	_, err := New(
+		WithEndpoints(url.URL{Path: "/nohost"}),
+		WithTimeout(-1*time.Second),
+		WithBreaker(0, 150, -1),
+		WithRetries(-1, -1*time.Millisecond),
+	)
+
+	configErr, ok := err.(ConfigError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Timeout must be greater than zero, got -1s",
+		"MaxConcurrentRequests must be at least 1, got 0",
+		"ErrorPercentThreshold must be between 1 and 100, got 150",
+		"DefaultVolumeThreshold must not be negative, got -1",
+		"Retries must not be negative, got -1",
+		"RetryDelay must not be negative, got -1ms",
+		`endpoint "/nohost" has no host`,
+	}, configErr.Problems)
+}
+
+func TestValidateConfigChecksLimits(t *testing.T) {
+	tests := map[string]func(c *Config){
+		"Cache.Size must not be negative, got -1":      func(c *Config) { c.Cache.Size = -1 },
+		"Cache.TTL must not be negative, got -1s":      func(c *Config) { c.Cache.TTL = -1 * time.Second },
+		"Cache.MaxStale must not be negative, got -1s": func(c *Config) { c.Cache.MaxStale = -1 * time.Second },
+		"RateLimit.Rate must not be negative, got -1":  func(c *Config) { c.RateLimit.Rate = -1 },
+		"RateLimit.Burst must not be negative, got -1": func(c *Config) { c.RateLimit.Burst = -1 },
+		"EndpointRateLimit.Rate must not be negative, got -1": func(c *Config) {
+			c.EndpointRateLimit.Rate = -1
+		},
+		"EndpointRateLimit.Burst must not be negative, got -1": func(c *Config) {
+			c.EndpointRateLimit.Burst = -1
+		},
+		"AdaptiveConcurrency.InitialLimit must not be negative, got -1": func(c *Config) {
+			c.AdaptiveConcurrency.InitialLimit = -1
+		},
+		"AdaptiveConcurrency.MinLimit must not be negative, got -1": func(c *Config) {
+			c.AdaptiveConcurrency.MinLimit = -1
+		},
+		"AdaptiveConcurrency.MaxLimit must not be negative, got -1": func(c *Config) {
+			c.AdaptiveConcurrency.MaxLimit = -1
+		},
+		"AdaptiveConcurrency.MinLimit must not be greater than MaxLimit, got 10 and 5": func(c *Config) {
+			c.AdaptiveConcurrency.MinLimit = 10
+			c.AdaptiveConcurrency.MaxLimit = 5
+		},
+		"AdaptiveConcurrency.InitialLimit must not be greater than MaxLimit, got 10 and 5": func(c *Config) {
+			c.AdaptiveConcurrency.InitialLimit = 10
+			c.AdaptiveConcurrency.MaxLimit = 5
+		},
+		"AdaptiveConcurrency.BackoffRatio must be at least 0 and less than 1, got 1.5": func(c *Config) {
+			c.AdaptiveConcurrency.BackoffRatio = 1.5
+		},
+		"AdaptiveConcurrency.LatencyThreshold must not be negative, got -1s": func(c *Config) {
+			c.AdaptiveConcurrency.LatencyThreshold = -1 * time.Second
+		},
+		"LoadShedding.HighThreshold must be between 0 and 1, got 2": func(c *Config) {
+			c.LoadShedding.HighThreshold = 2
+		},
+		"LoadShedding.LowThreshold must not be greater than NormalThreshold, got 0.9 and 0.8": func(c *Config) {
+			c.LoadShedding.LowThreshold = 0.9
+		},
+		`Bulkheads["reports"].MaxConcurrentRequests must not be negative, got -1`: func(c *Config) {
+			c.Bulkheads = map[string]Bulkhead{"reports": {MaxConcurrentRequests: -1}}
+		},
+		`Bulkheads["reports"].QueueTimeout must not be negative, got -1s`: func(c *Config) {
+			c.Bulkheads = map[string]Bulkhead{"reports": {QueueTimeout: -1 * time.Second}}
+		},
+		"DefaultBulkhead.MaxConcurrentRequests must not be negative, got -1": func(c *Config) {
+			c.DefaultBulkhead.MaxConcurrentRequests = -1
+		},
+		"DefaultBulkhead.QueueTimeout must not be negative, got -1s": func(c *Config) {
+			c.DefaultBulkhead.QueueTimeout = -1 * time.Second
+		},
+	}
+
+	for expected, change := range tests {
+		config := defaultOptions().config
+		config.Endpoints = urls
+		change(&config)
+
+		configErr, ok := validateConfig(config).(ConfigError)
+		if assert.True(t, ok, expected) {
+			assert.Equal(t, []string{expected}, configErr.Problems)
+		}
+	}
+}
+
+func TestValidateConfigAcceptsDefaultLimits(t *testing.T) {
+	config := defaultOptions().config
+	config.Endpoints = urls
+	config.AdaptiveConcurrency = AdaptiveConcurrency{InitialLimit: 10, BackoffRatio: 0.5}
+	config.LoadShedding = LoadShedding{Enabled: true, NormalThreshold: 0.6}
+	config.Bulkheads = map[string]Bulkhead{"reports": {MaxConcurrentRequests: 2}}
+
+	assert.Nil(t, validateConfig(config))
+}
+
+func TestNewWithConfigIsValidated(t *testing.T) {
+	_, err := New(WithConfig(Config{Endpoints: urls, Timeout: 1 * time.Second}))
+
+	assert.Contains(t, err.Error(), "MaxConcurrentRequests must be at least 1")
+}
+
+func TestNewReturnsErrorForNilStrategy(t *testing.T) {
+	_, err := New(WithEndpoints(urls...), WithStrategy(nil))
+
+	assert.NotNil(t, err)
+}
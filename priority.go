@@ -0,0 +1,218 @@
+package ultraclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Priority is the importance of the work, when the client is under load work
+// with a lower priority is shed first
+type Priority int
+
+const (
+	// PriorityLow is for work such as batch jobs which can be retried later
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the priority of work which has not been given one
+	PriorityNormal
+	// PriorityHigh is for work which should only be shed under heavy load
+	PriorityHigh
+	// PriorityCritical is for work which is never shed
+	PriorityCritical
+)
+
+// String returns the name of the priority used in stats tags
+func (p Priority) String() string {
+	switch {
+	case p <= PriorityLow:
+		return "low"
+	case p == PriorityNormal:
+		return "normal"
+	case p == PriorityHigh:
+		return "high"
+	default:
+		return "critical"
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context which gives the priority to the work
+// performed with it
+// ctx := ultraclient.WithPriority(ctx, ultraclient.PriorityCritical)
+// err := client.DoContext(ctx, checkout)
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority given to the context, work without
+// a priority is PriorityNormal
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+
+	return PriorityNormal
+}
+
+var errLoadShed = errors.New(ErrorLoadShed)
+
+// shed rejects the work when the load on the client is above the threshold
+// for the priority of the work, rejected work is not retried.  Admitted work
+// must be reported to shedDone once complete.
+func (c *ClientImpl) shed(ctx context.Context, endpoint *url.URL) error {
+	if c.loadShedder == nil {
+		return nil
+	}
+
+	priority := PriorityFromContext(ctx)
+	tags := append(c.statsTags(nil), "priority:"+priority.String())
+
+	if !c.loadShedder.admit(priority) {
+		c.incrementTaggedStats(tags, StatsLoadShed)
+		return ClientError{Message: ErrorLoadShed, URL: *endpoint, Err: NonRetryable(errLoadShed)}
+	}
+
+	c.incrementTaggedStats(tags, StatsAdmitted)
+	return nil
+}
+
+func (c *ClientImpl) shedDone(result limitOutcome) {
+	if c.loadShedder != nil {
+		c.loadShedder.done(result)
+	}
+}
+
+// loadShedder measures the load on the client from the requests in flight and
+// the recent error rate, it is shared between a client and its clones
+type loadShedder struct {
+	sync.Mutex
+	thresholds             map[Priority]float64
+	maxConcurrentRequests  int
+	errorPercentThreshold  int
+	requestVolumeThreshold int
+	inFlight               int
+	window                 [10]shedderBucket
+	now                    func() time.Time
+}
+
+// shedderBucket counts the requests completed in one second
+type shedderBucket struct {
+	second   int64
+	requests int
+	errors   int
+}
+
+func newLoadShedder(config Config) *loadShedder {
+	if !config.LoadShedding.Enabled {
+		return nil
+	}
+
+	thresholds := map[Priority]float64{
+		PriorityLow:    config.LoadShedding.LowThreshold,
+		PriorityNormal: config.LoadShedding.NormalThreshold,
+		PriorityHigh:   config.LoadShedding.HighThreshold,
+	}
+
+	defaults := map[Priority]float64{PriorityLow: 0.5, PriorityNormal: 0.8, PriorityHigh: 0.95}
+	for priority, threshold := range thresholds {
+		if threshold <= 0 {
+			thresholds[priority] = defaults[priority]
+		}
+	}
+
+	return &loadShedder{
+		thresholds:             thresholds,
+		maxConcurrentRequests:  config.MaxConcurrentRequests,
+		errorPercentThreshold:  config.ErrorPercentThreshold,
+		requestVolumeThreshold: config.DefaultVolumeThreshold,
+		now:                    time.Now,
+	}
+}
+
+// admit returns true and counts the request as in flight when the load is
+// below the threshold for the priority
+func (l *loadShedder) admit(priority Priority) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if priority < PriorityCritical && l.load() >= l.threshold(priority) {
+		return false
+	}
+
+	l.inFlight++
+	return true
+}
+
+// done records the outcome of an admitted request
+func (l *loadShedder) done(result limitOutcome) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.inFlight--
+
+	if result == outcomeIgnored {
+		return
+	}
+
+	bucket := l.bucket()
+	bucket.requests++
+	if result == outcomeDropped {
+		bucket.errors++
+	}
+}
+
+func (l *loadShedder) threshold(priority Priority) float64 {
+	if priority < PriorityLow {
+		priority = PriorityLow
+	}
+
+	return l.thresholds[priority]
+}
+
+// load returns how close the client is to its concurrency and error limits,
+// 1 is at the limit
+func (l *loadShedder) load() float64 {
+	load := 0.0
+
+	if l.maxConcurrentRequests > 0 {
+		load = float64(l.inFlight) / float64(l.maxConcurrentRequests)
+	}
+
+	if l.errorPercentThreshold > 0 {
+		requests, errors := l.totals()
+		if requests > 0 && requests >= l.requestVolumeThreshold {
+			errorPercent := float64(errors) * 100 / float64(requests)
+			load = math.Max(load, errorPercent/float64(l.errorPercentThreshold))
+		}
+	}
+
+	return load
+}
+
+func (l *loadShedder) bucket() *shedderBucket {
+	second := l.now().Unix()
+	bucket := &l.window[second%int64(len(l.window))]
+
+	if bucket.second != second {
+		*bucket = shedderBucket{second: second}
+	}
+
+	return bucket
+}
+
+func (l *loadShedder) totals() (requests, errors int) {
+	oldest := l.now().Unix() - int64(len(l.window))
+
+	for _, bucket := range l.window {
+		if bucket.second > oldest {
+			requests += bucket.requests
+			errors += bucket.errors
+		}
+	}
+
+	return requests, errors
+}
@@ -0,0 +1,105 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func noopWork(ctx context.Context, endpoint url.URL) error {
+	return nil
+}
+
+func setupLoadShedder(config Config) *loadShedder {
+	config.LoadShedding.Enabled = true
+	client.loadShedder = newLoadShedder(config)
+
+	return client.loadShedder
+}
+
+func TestPriorityDefaultsToNormal(t *testing.T) {
+	assert.Equal(t, PriorityNormal, PriorityFromContext(context.Background()))
+}
+
+func TestPriorityIsReadFromContext(t *testing.T) {
+	ctx := WithPriority(context.Background(), PriorityCritical)
+
+	assert.Equal(t, PriorityCritical, PriorityFromContext(ctx))
+}
+
+func TestLoadSheddingRejectsLowPriorityWorkFirst(t *testing.T) {
+	setupClient(0)
+	shedder := setupLoadShedder(Config{MaxConcurrentRequests: 2})
+
+	// work already in flight puts the client at half its concurrency
+	shedder.admit(PriorityCritical)
+
+	err := client.DoContext(WithPriority(context.Background(), PriorityLow), noopWork)
+	assert.Equal(t, ErrorLoadShed, err.(ClientError).Message)
+
+	err = client.DoContext(context.Background(), noopWork)
+	assert.Nil(t, err)
+
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.loadshed", append(client.config.StatsD.Tags, "priority:low"), mock.Anything)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.admitted", append(client.config.StatsD.Tags, "priority:normal"), mock.Anything)
+}
+
+func TestLoadSheddingDoesNotRetryRejectedWork(t *testing.T) {
+	setupClient(2)
+	shedder := setupLoadShedder(Config{MaxConcurrentRequests: 1})
+	shedder.admit(PriorityCritical)
+
+	client.DoContext(context.Background(), noopWork)
+
+	mockStats.AssertNumberOfCalls(t, "Increment", 1)
+}
+
+func TestLoadSheddingNeverRejectsCriticalWork(t *testing.T) {
+	setupClient(0)
+	shedder := setupLoadShedder(Config{MaxConcurrentRequests: 1})
+	shedder.admit(PriorityCritical)
+
+	err := client.DoContext(WithPriority(context.Background(), PriorityCritical), noopWork)
+
+	assert.Nil(t, err)
+}
+
+func TestLoadSheddingReleasesWorkWhenComplete(t *testing.T) {
+	setupClient(0)
+	shedder := setupLoadShedder(Config{MaxConcurrentRequests: 1})
+
+	assert.Nil(t, client.DoContext(context.Background(), noopWork))
+	assert.Nil(t, client.DoContext(context.Background(), noopWork))
+	assert.Equal(t, 0, shedder.inFlight)
+}
+
+func TestLoadSheddingRejectsWorkWhenErrorRateIsHigh(t *testing.T) {
+	now := time.Now()
+	shedder := newLoadShedder(Config{
+		ErrorPercentThreshold:  50,
+		DefaultVolumeThreshold: 2,
+		LoadShedding:           LoadShedding{Enabled: true},
+	})
+	shedder.now = func() time.Time { return now }
+
+	shedder.admit(PriorityNormal)
+	shedder.done(outcomeDropped)
+	assert.True(t, shedder.admit(PriorityHigh), "below the request volume threshold")
+	shedder.done(outcomeSuccess)
+
+	assert.False(t, shedder.admit(PriorityNormal))
+	assert.True(t, shedder.admit(PriorityCritical))
+	shedder.done(outcomeIgnored)
+
+	now = now.Add(11 * time.Second)
+
+	assert.True(t, shedder.admit(PriorityLow))
+}
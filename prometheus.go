@@ -0,0 +1,264 @@
+package ultraclient
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus implements an ExtendedStats which records metrics with the
+// Prometheus client, Increment and Count are recorded with a counter, Timing
+// and Histogram with a histogram and Gauge with a gauge.  The bucket name is
+// converted to the metric name, myapp.called becomes myapp_called_total and
+// myapp.timing becomes myapp_timing_seconds.  Tags in the form key:value
+// become labels, the labels of a metric are fixed by the first call and every
+// metric has a server label so the client can record a metric both for an
+// endpoint and without one.  Labels missing from later calls are left empty,
+// a call with a label the metric does not have is reported and not recorded.
+type Prometheus struct {
+	registerer prometheus.Registerer
+	buckets    []float64
+
+	sync.Mutex
+	counters   map[string]*prometheusVec[*prometheus.CounterVec]
+	histograms map[string]*prometheusVec[*prometheus.HistogramVec]
+	gauges     map[string]*prometheusVec[*prometheus.GaugeVec]
+}
+
+// prometheusServerLabel is the label given to every metric for the server
+// tag of the client
+const prometheusServerLabel = "server"
+
+// prometheusVec is a registered metric and the names of its labels
+type prometheusVec[T prometheus.Collector] struct {
+	name   string
+	vec    T
+	labels []string
+}
+
+// NewPrometheus creates a new implementation of the Prometheus metrics
+// client, metrics are registered with the registerer or with
+// prometheus.DefaultRegisterer when nil.  Timings and histograms are recorded
+// in the given buckets, timings are in seconds, prometheus.DefBuckets is used
+// when no buckets are given.
+// stats := ultraclient.NewPrometheus(registry, 0.005, 0.01, 0.05, 0.1, 0.5, 1)
+func NewPrometheus(registerer prometheus.Registerer, buckets ...float64) *Prometheus {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	return &Prometheus{
+		registerer: registerer,
+		buckets:    buckets,
+		counters:   make(map[string]*prometheusVec[*prometheus.CounterVec]),
+		histograms: make(map[string]*prometheusVec[*prometheus.HistogramVec]),
+		gauges:     make(map[string]*prometheusVec[*prometheus.GaugeVec]),
+	}
+}
+
+// Increment adds 1 to the counter for the bucket
+func (p *Prometheus) Increment(name string, tags []string, rate float64) {
+	p.Count(name, tags, 1, rate)
+}
+
+// Count adds the delta to the counter for the bucket, Prometheus counters can
+// not decrease so a negative delta is dropped
+func (p *Prometheus) Count(name string, tags []string, delta int64, rate float64) {
+	if delta < 0 {
+		fmt.Printf("counter %v can not be decreased by %v\n", name, delta)
+		return
+	}
+
+	labels := tagsToLabels(tags)
+
+	counter, err := p.counter(metricName(name)+"_total", labels)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	values, err := counter.values(labels)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	counter.vec.With(values).Add(float64(delta))
+}
+
+// Timing records the duration in seconds in the histogram for the bucket
+func (p *Prometheus) Timing(name string, tags []string, duration time.Duration, rate float64) {
+	p.observe(metricName(name)+"_seconds", tags, duration.Seconds())
+}
+
+// Histogram records the value in the histogram for the bucket
+func (p *Prometheus) Histogram(name string, tags []string, value float64, rate float64) {
+	p.observe(metricName(name), tags, value)
+}
+
+// Gauge sets the gauge for the bucket to the value
+func (p *Prometheus) Gauge(name string, tags []string, value float64, rate float64) {
+	labels := tagsToLabels(tags)
+
+	gauge, err := p.gauge(metricName(name), labels)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	values, err := gauge.values(labels)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	gauge.vec.With(values).Set(value)
+}
+
+func (p *Prometheus) observe(name string, tags []string, value float64) {
+	labels := tagsToLabels(tags)
+
+	histogram, err := p.histogram(name, labels)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	values, err := histogram.values(labels)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	histogram.vec.With(values).Observe(value)
+}
+
+func (p *Prometheus) counter(name string, labels prometheus.Labels) (*prometheusVec[*prometheus.CounterVec], error) {
+	return register(p, p.counters, name, labels, func(names []string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: "ultraclient " + name}, names)
+	})
+}
+
+func (p *Prometheus) histogram(name string, labels prometheus.Labels) (*prometheusVec[*prometheus.HistogramVec], error) {
+	return register(p, p.histograms, name, labels, func(names []string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    name,
+			Help:    "ultraclient " + name,
+			Buckets: p.buckets,
+		}, names)
+	})
+}
+
+func (p *Prometheus) gauge(name string, labels prometheus.Labels) (*prometheusVec[*prometheus.GaugeVec], error) {
+	return register(p, p.gauges, name, labels, func(names []string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: "ultraclient " + name}, names)
+	})
+}
+
+// register returns the metric from vecs, the metric is created with the
+// labels and the server label and registered on first use.  A metric which has already been
+// registered by another Prometheus with the same registerer is reused.
+func register[T prometheus.Collector](
+	p *Prometheus,
+	vecs map[string]*prometheusVec[T],
+	name string,
+	labels prometheus.Labels,
+	create func(names []string) T) (*prometheusVec[T], error) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	if vec, ok := vecs[name]; ok {
+		return vec, nil
+	}
+
+	names := labelNames(labels)
+	if _, ok := labels[prometheusServerLabel]; !ok {
+		names = append(names, prometheusServerLabel)
+		sort.Strings(names)
+	}
+
+	vec := create(names)
+
+	if err := p.registerer.Register(vec); err != nil {
+		existing, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+
+		if vec, ok = existing.ExistingCollector.(T); !ok {
+			return nil, err
+		}
+	}
+
+	registered := &prometheusVec[T]{name: name, vec: vec, labels: names}
+	vecs[name] = registered
+
+	return registered, nil
+}
+
+// values returns the labels of the metric with the values from the tags, an
+// error is returned when the tags have a label the metric does not have
+func (p *prometheusVec[T]) values(labels prometheus.Labels) (prometheus.Labels, error) {
+	values := prometheus.Labels{}
+	for _, name := range p.labels {
+		values[name] = labels[name]
+	}
+
+	if len(values) < len(labels) {
+		for _, name := range labelNames(labels) {
+			if _, ok := values[name]; !ok {
+				return nil, fmt.Errorf("metric %v does not have the label %v, the labels of a metric are fixed by the first call", p.name, name)
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// tagsToLabels converts tags in the form key:value to labels, a tag without a
+// value is given the value true
+func tagsToLabels(tags []string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "true")
+		}
+
+		labels[metricName(parts[0])] = parts[1]
+	}
+
+	return labels
+}
+
+func labelNames(labels prometheus.Labels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// metricName replaces the characters which are not valid in a Prometheus
+// metric or label name with underscores
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
@@ -0,0 +1,164 @@
+package ultraclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupPrometheus(t *testing.T) (*Prometheus, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	return NewPrometheus(registry, 0.1, 1), registry
+}
+
+func TestPrometheusIncrementAddsToCounterWithLabels(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Increment("myapp.called", []string{"server:localhost"}, 1)
+	stats.Increment("myapp.called", []string{"server:localhost"}, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_called_total ultraclient myapp_called_total
+# TYPE myapp_called_total counter
+myapp_called_total{server="localhost"} 2
+`), "myapp_called_total")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusTimingObservesHistogramInSeconds(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Timing("myapp.timing", []string{"server:localhost"}, 500*time.Millisecond, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_timing_seconds ultraclient myapp_timing_seconds
+# TYPE myapp_timing_seconds histogram
+myapp_timing_seconds_bucket{server="localhost",le="0.1"} 0
+myapp_timing_seconds_bucket{server="localhost",le="1"} 1
+myapp_timing_seconds_bucket{server="localhost",le="+Inf"} 1
+myapp_timing_seconds_sum{server="localhost"} 0.5
+myapp_timing_seconds_count{server="localhost"} 1
+`), "myapp_timing_seconds")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusCountAddsDeltaToCounter(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Count("myapp.items", nil, 3, 1)
+	stats.Count("myapp.items", nil, -1, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_items_total ultraclient myapp_items_total
+# TYPE myapp_items_total counter
+myapp_items_total{server=""} 3
+`), "myapp_items_total")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusGaugeSetsValue(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Gauge("myapp.inflight", []string{"env:prod"}, 4, 1)
+	stats.Gauge("myapp.inflight", []string{"env:prod"}, 2, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_inflight ultraclient myapp_inflight
+# TYPE myapp_inflight gauge
+myapp_inflight{env="prod",server=""} 2
+`), "myapp_inflight")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusHistogramObservesValue(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Histogram("myapp.size", nil, 0.5, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_size ultraclient myapp_size
+# TYPE myapp_size histogram
+myapp_size_bucket{server="",le="0.1"} 0
+myapp_size_bucket{server="",le="1"} 1
+myapp_size_bucket{server="",le="+Inf"} 1
+myapp_size_sum{server=""} 0.5
+myapp_size_count{server=""} 1
+`), "myapp_size")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusKeepsServerLabelWhenFirstCallHasNoServer(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Increment("myapp.ratelimited", []string{"env:prod"}, 1)
+	stats.Increment("myapp.ratelimited", []string{"server:localhost", "env:prod"}, 1)
+	stats.Increment("myapp.ratelimited", []string{"server:localhost", "env:prod"}, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_ratelimited_total ultraclient myapp_ratelimited_total
+# TYPE myapp_ratelimited_total counter
+myapp_ratelimited_total{env="prod",server=""} 1
+myapp_ratelimited_total{env="prod",server="localhost"} 2
+`), "myapp_ratelimited_total")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusDoesNotRecordCallWithUnknownLabel(t *testing.T) {
+	stats, registry := setupPrometheus(t)
+
+	stats.Increment("myapp.called", []string{"server:localhost", "env:prod"}, 1)
+	stats.Increment("myapp.called", []string{"server:localhost", "env:prod", "extra"}, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_called_total ultraclient myapp_called_total
+# TYPE myapp_called_total counter
+myapp_called_total{env="prod",server="localhost"} 1
+`), "myapp_called_total")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusValuesReturnsErrorForUnknownLabel(t *testing.T) {
+	vec := &prometheusVec[*prometheus.CounterVec]{name: "myapp_called_total", labels: []string{"server"}}
+
+	_, err := vec.values(prometheus.Labels{"server": "localhost", "extra": "true"})
+
+	assert.EqualError(t, err, "metric myapp_called_total does not have the label extra, the labels of a metric are fixed by the first call")
+}
+
+func TestPrometheusReusesRegisteredCollectors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first := NewPrometheus(registry)
+	second := NewPrometheus(registry)
+
+	first.Increment("myapp.called", []string{"server:localhost"}, 1)
+	second.Increment("myapp.called", []string{"server:localhost"}, 1)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myapp_called_total ultraclient myapp_called_total
+# TYPE myapp_called_total counter
+myapp_called_total{server="localhost"} 2
+`), "myapp_called_total")
+
+	assert.Nil(t, err)
+}
+
+func TestPrometheusConvertsTagsToLabels(t *testing.T) {
+	labels := tagsToLabels([]string{"server:localhost:8080", "canary", "my-tag:x"})
+
+	assert.Equal(t, prometheus.Labels{
+		"server": "localhost:8080",
+		"canary": "true",
+		"my_tag": "x",
+	}, labels)
+}
@@ -0,0 +1,193 @@
+package ultraclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var errRateLimited = errors.New(ErrorRateLimited)
+
+// rateLimit applies the client and endpoint rate limits before work is
+// performed against the endpoint.  Work rejected by the client limit is not
+// retried, work rejected by the endpoint limit is retried so that it can be
+// performed against another endpoint.
+func (c *ClientImpl) rateLimit(ctx context.Context, endpoint *url.URL) error {
+	if c.rateLimiters == nil {
+		return nil
+	}
+
+	if limiter := c.rateLimiters.client; limiter != nil {
+		if err := limiter.take(ctx); err != nil {
+			if err == errRateLimited {
+				c.incrementStats(nil, StatsRateLimited)
+				return ClientError{Message: ErrorRateLimited, URL: *endpoint, Err: NonRetryable(err)}
+			}
+
+			return ClientError{Message: err.Error(), URL: *endpoint, Err: err}
+		}
+	}
+
+	if limiter := c.rateLimiters.endpoint(endpoint); limiter != nil {
+		if err := limiter.take(ctx); err != nil {
+			if err == errRateLimited {
+				c.incrementStats(endpoint, StatsRateLimited)
+				return ClientError{Message: ErrorRateLimited, URL: *endpoint}
+			}
+
+			return ClientError{Message: err.Error(), URL: *endpoint, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// rateLimiters holds the limiter for the client and a limiter for each
+// endpoint, it is shared between a client and its clones
+type rateLimiters struct {
+	client *tokenBucket
+
+	sync.Mutex
+	endpointConfig RateLimit
+	endpoints      map[string]*tokenBucket
+}
+
+func newRateLimiters(client, endpoint RateLimit) *rateLimiters {
+	if client.Rate <= 0 && endpoint.Rate <= 0 {
+		return nil
+	}
+
+	r := &rateLimiters{
+		endpointConfig: endpoint,
+		endpoints:      make(map[string]*tokenBucket),
+	}
+
+	if client.Rate > 0 {
+		r.client = newTokenBucket(client)
+	}
+
+	return r
+}
+
+func (r *rateLimiters) endpoint(endpoint *url.URL) *tokenBucket {
+	if r.endpointConfig.Rate <= 0 {
+		return nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	limiter, ok := r.endpoints[endpoint.String()]
+	if !ok {
+		limiter = newTokenBucket(r.endpointConfig)
+		r.endpoints[endpoint.String()] = limiter
+	}
+
+	return limiter
+}
+
+// prune removes the limiters of endpoints which are no longer used by the
+// client
+func (r *rateLimiters) prune(endpoints []url.URL) {
+	r.Lock()
+	defer r.Unlock()
+
+	current := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		current[endpoint.String()] = true
+	}
+
+	for endpoint := range r.endpoints {
+		if !current[endpoint] {
+			delete(r.endpoints, endpoint)
+		}
+	}
+}
+
+// tokenBucket is a token bucket rate limiter, tokens are added at the
+// configured rate up to the burst and each request takes a token
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	wait   bool
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(config RateLimit) *tokenBucket {
+	burst := float64(config.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   config.Rate,
+		burst:  burst,
+		wait:   config.Wait,
+		tokens: burst,
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// take takes a token from the bucket, when no token is available
+// errRateLimited is returned unless the bucket is configured to wait in which
+// case it waits for a token or for the context to be done
+func (t *tokenBucket) take(ctx context.Context) error {
+	delay, ok := t.reserve()
+	if !ok {
+		return errRateLimited
+	}
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		t.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token returning the time to wait until the token is
+// available, when waiting is disabled ok is false if there is no token
+func (t *tokenBucket) reserve() (delay time.Duration, ok bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	t.tokens = math.Min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+
+	if t.tokens >= 1 {
+		t.tokens--
+		return 0, true
+	}
+
+	if !t.wait {
+		return 0, false
+	}
+
+	// the token is borrowed from the future, the bucket goes negative
+	t.tokens--
+	return time.Duration(-t.tokens / t.rate * float64(time.Second)), true
+}
+
+// cancel returns a reserved token which was not used
+func (t *tokenBucket) cancel() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.tokens = math.Min(t.burst, t.tokens+1)
+}
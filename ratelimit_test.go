@@ -0,0 +1,120 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClientRateLimitRejectsWorkWithoutRetrying(t *testing.T) {
+	setupClient(2)
+	client.rateLimiters = newRateLimiters(RateLimit{Rate: 1}, RateLimit{})
+
+	callCount := 0
+	work := func(endpoint url.URL) error {
+		callCount++
+		return nil
+	}
+
+	assert.Nil(t, client.Do(work))
+	err := client.Do(work)
+
+	assert.Equal(t, ErrorRateLimited, err.(ClientError).Message)
+	assert.Equal(t, 1, callCount)
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.ratelimited", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestEndpointRateLimitRetriesAnotherEndpoint(t *testing.T) {
+	setupClient(1)
+	client.rateLimiters = newRateLimiters(RateLimit{}, RateLimit{Rate: 1})
+
+	var hosts []string
+	work := func(endpoint url.URL) error {
+		hosts = append(hosts, endpoint.Host)
+		return nil
+	}
+
+	assert.Nil(t, client.Do(work))
+	urlIndex = 0
+	assert.Nil(t, client.Do(work))
+
+	assert.Equal(t, []string{"something:3232", "somethingelse:2323"}, hosts)
+
+	tags := append(client.config.StatsD.Tags, "server:something_3232")
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.ratelimited", tags, mock.Anything)
+}
+
+func TestEndpointRateLimitersAreRemovedWithTheirEndpoints(t *testing.T) {
+	setupClient(0)
+	client.rateLimiters = newRateLimiters(RateLimit{}, RateLimit{Rate: 1})
+
+	work := func(endpoint url.URL) error {
+		return nil
+	}
+
+	assert.Nil(t, client.Do(work))
+	client.UpdateEndpoints([]url.URL{urls[1]})
+	assert.Nil(t, client.Do(work))
+
+	assert.Len(t, client.rateLimiters.endpoints, 1)
+	assert.NotNil(t, client.rateLimiters.endpoints[urls[1].String()])
+}
+
+func TestRateLimitWaitsForToken(t *testing.T) {
+	setupClient(0)
+	client.rateLimiters = newRateLimiters(RateLimit{Rate: 20, Wait: true}, RateLimit{})
+
+	work := func(endpoint url.URL) error {
+		return nil
+	}
+
+	startTime := time.Now()
+	assert.Nil(t, client.Do(work))
+	assert.Nil(t, client.Do(work))
+
+	assert.True(t, time.Now().Sub(startTime) >= 40*time.Millisecond)
+}
+
+func TestRateLimitStopsWaitingWhenContextIsDone(t *testing.T) {
+	setupClient(0)
+	client.rateLimiters = newRateLimiters(RateLimit{Rate: 0.1, Wait: true}, RateLimit{})
+
+	work := func(ctx context.Context, endpoint url.URL) error {
+		return nil
+	}
+
+	assert.Nil(t, client.DoContext(context.Background(), work))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	startTime := time.Now()
+	err := client.DoContext(ctx, work)
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Now().Sub(startTime) < 1*time.Second)
+}
+
+func TestTokenBucketRefillsAtRate(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(RateLimit{Rate: 10, Burst: 2})
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+
+	assert.Nil(t, bucket.take(context.Background()))
+	assert.Nil(t, bucket.take(context.Background()))
+	assert.Equal(t, errRateLimited, bucket.take(context.Background()))
+
+	now = now.Add(100 * time.Millisecond)
+
+	assert.Nil(t, bucket.take(context.Background()))
+	assert.Equal(t, errRateLimited, bucket.take(context.Background()))
+}
@@ -0,0 +1,340 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+// DefaultWatchInterval is the interval WatchConfigFile uses when it is not
+// given one
+const DefaultWatchInterval = 10 * time.Second
+
+// clientSettings are the settings of a client which can be changed by
+// Reload, they are shared with clones and are read with settings so work
+// always sees a consistent set
+type clientSettings struct {
+	lock sync.RWMutex
+
+	config Config
+	retry  *retryPolicy
+
+	// backoffStrategy creates the retry delays of retry
+	backoffStrategy BackoffStrategy
+
+	// metadata is the metadata of config.Endpoints in the same order
+	metadata []EndpointMetadata
+
+	// endpointsVersion changes each time Reload changes the endpoints
+	endpointsVersion int
+
+	// discoverers is the number of discoverers updating the endpoints
+	discoverers int
+}
+
+// settingsSnapshot is a copy of the settings taken under the lock
+type settingsSnapshot struct {
+	config           Config
+	retry            *retryPolicy
+	backoffStrategy  BackoffStrategy
+	metadata         []EndpointMetadata
+	endpointsVersion int
+	discoverers      int
+}
+
+func newClientSettings(config Config, metadata []EndpointMetadata, backoffStrategy BackoffStrategy) *clientSettings {
+	return &clientSettings{
+		config:          config,
+		retry:           newRetry(config, backoffStrategy),
+		backoffStrategy: backoffStrategy,
+		metadata:        metadata,
+	}
+}
+
+func newRetry(config Config, backoffStrategy BackoffStrategy) *retryPolicy {
+	return newRetryPolicy(
+		backoffStrategy.Create(config.Retries, config.RetryDelay),
+		config.MaxRetryAfter,
+	)
+}
+
+func (c *ClientImpl) settings() settingsSnapshot {
+	c.clientSettings.lock.RLock()
+	defer c.clientSettings.lock.RUnlock()
+
+	return settingsSnapshot{
+		config:           c.clientSettings.config,
+		retry:            c.clientSettings.retry,
+		backoffStrategy:  c.clientSettings.backoffStrategy,
+		metadata:         c.clientSettings.metadata,
+		endpointsVersion: c.clientSettings.endpointsVersion,
+		discoverers:      c.clientSettings.discoverers,
+	}
+}
+
+// updateSettings changes the settings under the lock
+func (c *ClientImpl) updateSettings(update func(settings *clientSettings)) {
+	c.clientSettings.lock.Lock()
+	defer c.clientSettings.lock.Unlock()
+
+	update(c.clientSettings)
+}
+
+// Reload applies the timeouts, error thresholds, retries and endpoints in the
+// config to the client and its clones.  Work already in flight completes with
+// the retries it started with.  The config is validated in the same way as New
+// and is rejected with a ConfigError when it is not valid.
+// MaxConcurrentRequests is fixed when the client is created and is not
+// changed, the cache, rate limit, concurrency limit, load shedding and
+// bulkhead settings are also fixed and a config which changes them is
+// rejected with a ConfigError.  While the client is subscribed to a Discoverer the
+// discovered endpoints are kept and the endpoints in the config are ignored.
+func (c *ClientImpl) Reload(config Config) error {
+	return c.reload(config, nil, nil)
+}
+
+// reload applies the config in the same way as Reload, a backoff strategy
+// which is not nil replaces the current one and a loadbalancing strategy
+// which is not nil must be the same kind as the current one
+func (c *ClientImpl) reload(config Config, loadbalancingStrategy LoadbalancingStrategy, backoffStrategy BackoffStrategy) error {
+	current := c.settings()
+
+	if loadbalancingStrategy != nil &&
+		reflect.TypeOf(loadbalancingStrategy) != reflect.TypeOf(c.loadbalancingStrategy) {
+		c.incrementStats(nil, StatsReloadError)
+		return ConfigError{Problems: []string{"the loadbalancing strategy can not be changed by Reload"}}
+	}
+
+	if backoffStrategy == nil {
+		backoffStrategy = current.backoffStrategy
+	}
+
+	config.MaxConcurrentRequests = current.config.MaxConcurrentRequests
+
+	metadata := defaultMetadata(config.Endpoints)
+	if current.discoverers > 0 {
+		config.Endpoints, metadata = current.config.Endpoints, current.metadata
+	}
+
+	// clients created by NewClient may have no limit, the circuit breaker
+	// then uses its default
+	checked := config
+	if checked.MaxConcurrentRequests == 0 {
+		checked.MaxConcurrentRequests = hystrix.DefaultMaxConcurrent
+	}
+
+	if err := validateConfig(checked); err != nil {
+		c.incrementStats(nil, StatsReloadError)
+		return err
+	}
+
+	if problems := fixedSettingsChanged(current.config, config); len(problems) > 0 {
+		c.incrementStats(nil, StatsReloadError)
+		return ConfigError{Problems: problems}
+	}
+
+	if config.Retries < 1 {
+		config.Retries = len(config.Endpoints) - 1
+	}
+
+	configureCommands(config.Endpoints, config)
+	retry := newRetry(config, backoffStrategy)
+
+	endpointsChanged := false
+	c.updateSettings(func(settings *clientSettings) {
+		// endpoints discovered since the config was read are kept
+		if settings.discoverers > 0 {
+			config.Endpoints, metadata = settings.config.Endpoints, settings.metadata
+		}
+
+		if !sameEndpoints(settings, config.Endpoints, metadata) {
+			settings.endpointsVersion++
+			endpointsChanged = true
+		}
+
+		settings.config = config
+		settings.retry = retry
+		settings.backoffStrategy = backoffStrategy
+		settings.metadata = metadata
+	})
+
+	if endpointsChanged {
+		c.publishEndpointGauges()
+	}
+
+	c.incrementStats(nil, StatsReload)
+	return nil
+}
+
+// fixedSettingsChanged describes each setting which is fixed when the client
+// is created and is different in the new config
+func fixedSettingsChanged(current, config Config) []string {
+	var problems []string
+	fixed := func(name string, changed bool) {
+		if changed {
+			problems = append(problems, name+" can not be changed by Reload")
+		}
+	}
+
+	fixed("Cache", !reflect.DeepEqual(current.Cache, config.Cache))
+	fixed("RateLimit", !reflect.DeepEqual(current.RateLimit, config.RateLimit))
+	fixed("EndpointRateLimit", !reflect.DeepEqual(current.EndpointRateLimit, config.EndpointRateLimit))
+	fixed("AdaptiveConcurrency", !reflect.DeepEqual(current.AdaptiveConcurrency, config.AdaptiveConcurrency))
+	fixed("LoadShedding", !reflect.DeepEqual(current.LoadShedding, config.LoadShedding))
+	fixed("Bulkheads", (len(current.Bulkheads) > 0 || len(config.Bulkheads) > 0) &&
+		!reflect.DeepEqual(current.Bulkheads, config.Bulkheads))
+	fixed("DefaultBulkhead", !reflect.DeepEqual(current.DefaultBulkhead, config.DefaultBulkhead))
+
+	return problems
+}
+
+// syncEndpoints passes endpoints changed by Reload or UpdateEndpoints to the loadbalancing
+// strategy and removes the rate and concurrency limiters of endpoints which have gone,
+// the caller must hold lbLock
+func (c *ClientImpl) syncEndpoints() {
+	settings := c.settings()
+	if settings.endpointsVersion == c.syncedVersion {
+		return
+	}
+
+	setEndpoints(c.loadbalancingStrategy, settings.config.Endpoints, settings.metadata)
+	c.syncedVersion = settings.endpointsVersion
+
+	if c.rateLimiters != nil {
+		c.rateLimiters.prune(settings.config.Endpoints)
+	}
+
+	if c.concurrencyLimiters != nil {
+		c.concurrencyLimiters.prune(settings.config.Endpoints)
+	}
+}
+
+// setEndpoints passes the metadata of the endpoints to strategies which
+// implement WeightedLoadbalancingStrategy
+func setEndpoints(strategy LoadbalancingStrategy, endpoints []url.URL, metadata []EndpointMetadata) {
+	if weighted, ok := strategy.(WeightedLoadbalancingStrategy); ok {
+		weighted.SetWeightedEndpoints(endpoints, metadata)
+		return
+	}
+
+	strategy.SetEndpoints(endpoints)
+}
+
+// sameEndpoints returns true when the endpoints and their metadata are the
+// same as the current settings, the caller must hold the settings lock
+func sameEndpoints(settings *clientSettings, endpoints []url.URL, metadata []EndpointMetadata) bool {
+	return reflect.DeepEqual(settings.config.Endpoints, endpoints) &&
+		reflect.DeepEqual(settings.metadata, metadata)
+}
+
+// configureCommands configures the circuit breaker for each endpoint
+func configureCommands(endpoints []url.URL, config Config) {
+	for _, url := range endpoints {
+		hystrix.ConfigureCommand(url.String(), hystrix.CommandConfig{
+			Timeout:                int(config.Timeout / time.Millisecond),
+			MaxConcurrentRequests:  config.MaxConcurrentRequests,
+			ErrorPercentThreshold:  config.ErrorPercentThreshold,
+			RequestVolumeThreshold: config.DefaultVolumeThreshold,
+		})
+	}
+}
+
+// WatchConfigFile checks the JSON or YAML file every interval and reloads the
+// client when the file changes, the file and options are applied in the same
+// way as NewClientFromConfigFile.  Errors loading the file or reloading the client
+// are sent to the returned channel, errors are dropped when the channel is
+// not read.  The file is watched until the context is done, an interval which
+// is not greater than zero is replaced with DefaultWatchInterval.  A backoff
+// strategy set by the file replaces the backoff of the client, a loadbalancing
+// strategy can not be changed and a different one is reported as an error.
+// errs := ultraclient.WatchConfigFile(ctx, client, "client.yaml", 10*time.Second)
+func WatchConfigFile(ctx context.Context, client Client, path string, interval time.Duration, opts ...Option) <-chan error {
+	errChan := make(chan error, 1)
+
+	report := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+	}
+
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	go func() {
+		defer close(errChan)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				report(err)
+				continue
+			}
+
+			if !info.ModTime().After(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+
+			fileOpts, err := loadFileOptions(path)
+			if err != nil {
+				report(err)
+				continue
+			}
+
+			if err := reloadOptions(client, append(fileOpts, opts...)); err != nil {
+				report(err)
+			}
+		}
+	}()
+
+	return errChan
+}
+
+// reloadOptions reloads the client with the options, strategies which are
+// set by the options are passed to clients which can apply them
+func reloadOptions(client Client, opts []Option) error {
+	o := defaultOptions()
+	defaults := *o
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	impl, ok := client.(*ClientImpl)
+	if !ok {
+		return client.Reload(o.config)
+	}
+
+	var loadbalancingStrategy LoadbalancingStrategy
+	if o.loadbalancingStrategy != defaults.loadbalancingStrategy {
+		loadbalancingStrategy = o.loadbalancingStrategy
+	}
+
+	var backoffStrategy BackoffStrategy
+	if o.backoffStrategy != defaults.backoffStrategy {
+		backoffStrategy = o.backoffStrategy
+	}
+
+	return impl.reload(o.config, loadbalancingStrategy, backoffStrategy)
+}
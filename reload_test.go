@@ -0,0 +1,291 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var reloadURLs = []url.URL{{Host: "reload1:8080"}, {Host: "reload2:8080"}}
+
+func validConfig() Config {
+	return Config{
+		Timeout:                100 * time.Millisecond,
+		MaxConcurrentRequests:  10,
+		ErrorPercentThreshold:  50,
+		DefaultVolumeThreshold: 20,
+		Retries:                3,
+		RetryDelay:             1 * time.Millisecond,
+		Endpoints:              reloadURLs,
+		StatsD:                 StatsD{Prefix: "myapp"},
+	}
+}
+
+func setupReloadClient(t *testing.T) (*ClientImpl, *MockStats) {
+	c, err := New(WithConfig(validConfig()))
+	assert.Nil(t, err)
+
+	stats := &MockStats{}
+	stats.On("Timing", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	stats.On("Increment", mock.Anything, mock.Anything, mock.Anything)
+	c.RegisterStats(stats)
+
+	return c.(*ClientImpl), stats
+}
+
+func TestReloadAppliesConfigToClientAndClones(t *testing.T) {
+	c, stats := setupReloadClient(t)
+	clone := c.Clone().(*ClientImpl)
+
+	config := validConfig()
+	config.Retries = 1
+	config.Timeout = 50 * time.Millisecond
+
+	err := c.Reload(config)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, clone.settings().config.Timeout)
+	assert.Len(t, clone.settings().retry.backoff, 1)
+	stats.AssertCalled(t, "Increment", "myapp.reload", []string(nil), mock.Anything)
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	c, stats := setupReloadClient(t)
+	before := c.settings()
+
+	config := validConfig()
+	config.ErrorPercentThreshold = 200
+
+	err := c.Reload(config)
+
+	assert.IsType(t, ConfigError{}, err)
+	assert.Equal(t, before, c.settings())
+	stats.AssertCalled(t, "Increment", "myapp.reloaderror", []string(nil), mock.Anything)
+}
+
+func TestReloadUpdatesEndpointsForClones(t *testing.T) {
+	c, _ := setupReloadClient(t)
+	clone := c.Clone().(*ClientImpl)
+
+	config := validConfig()
+	config.Endpoints = []url.URL{{Host: "reload3:8080"}}
+
+	assert.Nil(t, c.Reload(config))
+
+	assert.Equal(t, config.Endpoints, c.endpoints())
+	assert.Equal(t, config.Endpoints, clone.endpoints())
+	assert.Equal(t, config.Endpoints[0], clone.nextEndpoint())
+}
+
+func TestReloadDoesNotDropWorkInFlight(t *testing.T) {
+	c, _ := setupReloadClient(t)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	result := make(chan error)
+
+	go func() {
+		result <- c.Do(func(endpoint url.URL) error {
+			close(started)
+			<-finish
+			return nil
+		})
+	}()
+	<-started
+
+	config := validConfig()
+	config.Retries = 1
+	assert.Nil(t, c.Reload(config))
+
+	close(finish)
+	assert.Nil(t, <-result)
+}
+
+func TestReloadKeepsMaxConcurrentRequests(t *testing.T) {
+	c, _ := setupReloadClient(t)
+
+	config := validConfig()
+	config.MaxConcurrentRequests = 500
+
+	assert.Nil(t, c.Reload(config))
+	assert.Equal(t, 10, c.settings().config.MaxConcurrentRequests)
+	assert.Equal(t, 10, cap(c.asyncSlots))
+}
+
+func TestReloadKeepsDiscoveredEndpoints(t *testing.T) {
+	c, _, discoverer := setupDiscovery(t, 0)
+
+	discovered := []url.URL{{Host: "discovered:8080"}}
+	discoverer.updates <- discoveredEndpoints(discovered)
+	waitForEndpoints(c, discovered)
+
+	config := validConfig()
+	config.Endpoints = nil
+	config.Timeout = 50 * time.Millisecond
+
+	assert.Nil(t, c.Reload(config))
+	assert.Equal(t, 50*time.Millisecond, c.settings().config.Timeout)
+	assert.Equal(t, discovered, c.endpoints())
+}
+
+func TestExponentialBackoffIsSafeForConcurrentUse(t *testing.T) {
+	backoff := &ExponentialBackoff{}
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func(retries int) {
+			for j := 0; j < 100; j++ {
+				backoff.Create(retries, 1*time.Millisecond)
+			}
+			done <- true
+		}(i + 1)
+	}
+
+	<-done
+	<-done
+}
+
+func TestWatchConfigFileDefaultsInterval(t *testing.T) {
+	c, _ := setupReloadClient(t)
+	path := writeConfigFile(t, "client.yaml", "timeout: 100ms\nendpoints: [reload1:8080]")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := WatchConfigFile(ctx, c, path, 0)
+
+	cancel()
+	for range errs {
+	}
+}
+
+func TestExponentialBackoffIsRecreatedWhenRetriesChange(t *testing.T) {
+	backoff := &ExponentialBackoff{}
+
+	assert.Len(t, backoff.Create(3, 1*time.Millisecond), 3)
+	assert.Len(t, backoff.Create(1, 1*time.Millisecond), 1)
+}
+
+func TestWatchConfigFileReloadsClientWhenFileChanges(t *testing.T) {
+	c, _ := setupReloadClient(t)
+	path := writeConfigFile(t, "client.yaml", "timeout: 100ms\nendpoints: [reload1:8080]")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := WatchConfigFile(ctx, c, path, 5*time.Millisecond)
+
+	assert.Nil(t, os.WriteFile(path, []byte("timeout: 75ms\nendpoints: [reload1:8080]"), 0644))
+	future := time.Now().Add(1 * time.Minute)
+	assert.Nil(t, os.Chtimes(path, future, future))
+
+	deadline := time.Now().Add(1 * time.Second)
+	for c.settings().config.Timeout != 75*time.Millisecond && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, 75*time.Millisecond, c.settings().config.Timeout)
+
+	cancel()
+	for range errs {
+	}
+}
+
+func TestWatchConfigFileReportsInvalidFile(t *testing.T) {
+	c, _ := setupReloadClient(t)
+	path := writeConfigFile(t, "client.yaml", "timeout: 100ms\nendpoints: [reload1:8080]")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := WatchConfigFile(ctx, c, path, 5*time.Millisecond)
+
+	assert.Nil(t, os.WriteFile(path, []byte("timeout: 75ms"), 0644))
+	future := time.Now().Add(1 * time.Minute)
+	assert.Nil(t, os.Chtimes(path, future, future))
+
+	assert.IsType(t, ConfigError{}, <-errs)
+	assert.Equal(t, 100*time.Millisecond, c.settings().config.Timeout)
+}
+
+func TestReloadAcceptsClientWithoutMaxConcurrentRequests(t *testing.T) {
+	config := validConfig()
+	config.MaxConcurrentRequests = 0
+	c := NewClient(config, &RoundRobinStrategy{}, &ExponentialBackoff{}).(*ClientImpl)
+
+	config.Timeout = 50 * time.Millisecond
+
+	assert.Nil(t, c.Reload(config))
+	assert.Equal(t, 50*time.Millisecond, c.settings().config.Timeout)
+	assert.Equal(t, 0, c.settings().config.MaxConcurrentRequests)
+}
+
+func TestReloadRejectsChangesToFixedSettings(t *testing.T) {
+	c, stats := setupReloadClient(t)
+	before := c.settings()
+
+	config := validConfig()
+	config.RateLimit = RateLimit{Rate: 10, Burst: 10}
+	config.Bulkheads = map[string]Bulkhead{"reports": {MaxConcurrentRequests: 1}}
+
+	err := c.Reload(config)
+
+	assert.Equal(t, ConfigError{Problems: []string{
+		"RateLimit can not be changed by Reload",
+		"Bulkheads can not be changed by Reload",
+	}}, err)
+	assert.Equal(t, before, c.settings())
+	stats.AssertCalled(t, "Increment", "myapp.reloaderror", []string(nil), mock.Anything)
+}
+
+func TestReloadAcceptsEmptyBulkheads(t *testing.T) {
+	c, _ := setupReloadClient(t)
+
+	config := validConfig()
+	config.Bulkheads = map[string]Bulkhead{}
+
+	assert.Nil(t, c.Reload(config))
+}
+
+type customBackoff struct {
+	ExponentialBackoff
+}
+
+func TestReloadOptionsAppliesBackoffStrategy(t *testing.T) {
+	c, _ := setupReloadClient(t)
+	clone := c.Clone().(*ClientImpl)
+	backoff := &customBackoff{}
+
+	err := reloadOptions(c, []Option{WithConfig(validConfig()), WithBackoff(backoff)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, backoff, clone.settings().backoffStrategy)
+}
+
+func TestReloadOptionsKeepsBackoffStrategyWhenNotSet(t *testing.T) {
+	c, _ := setupReloadClient(t)
+	backoff := c.settings().backoffStrategy
+
+	assert.Nil(t, reloadOptions(c, []Option{WithConfig(validConfig())}))
+	assert.True(t, backoff == c.settings().backoffStrategy)
+}
+
+func TestReloadOptionsRejectsDifferentLoadbalancingStrategy(t *testing.T) {
+	c, stats := setupReloadClient(t)
+	before := c.settings()
+
+	err := reloadOptions(c, []Option{WithConfig(validConfig()), WithStrategy(&RandomStrategy{})})
+
+	assert.Equal(t, ConfigError{Problems: []string{"the loadbalancing strategy can not be changed by Reload"}}, err)
+	assert.Equal(t, before, c.settings())
+	stats.AssertCalled(t, "Increment", "myapp.reloaderror", []string(nil), mock.Anything)
+}
+
+func TestReloadOptionsAcceptsSameLoadbalancingStrategy(t *testing.T) {
+	c, _ := setupReloadClient(t)
+
+	assert.Nil(t, reloadOptions(c, []Option{WithConfig(validConfig()), WithStrategy(&RoundRobinStrategy{})}))
+}
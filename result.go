@@ -0,0 +1,66 @@
+package ultraclient
+
+import "sync"
+
+// FirstResult holds the value from the first successful attempt of a call.
+// An attempt which times out may still complete after the client has moved
+// on, so every value which is not going to be returned is passed to the
+// discard function to release it, e.g. closing a response body or a
+// connection.  It is safe for concurrent use by the attempts of a call.
+// result := ultraclient.NewFirstResult(func(conn net.Conn) { conn.Close() })
+type FirstResult[T any] struct {
+	lock     sync.Mutex
+	discard  func(T)
+	value    T
+	done     bool
+	finished bool
+}
+
+// NewFirstResult creates a FirstResult which passes values it does not keep
+// to discard, discard may be nil when values do not need to be released
+func NewFirstResult[T any](discard func(T)) *FirstResult[T] {
+	return &FirstResult[T]{discard: discard}
+}
+
+// Set keeps the value when it is the first and the call has not finished,
+// otherwise the value is discarded
+func (r *FirstResult[T]) Set(value T) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.done || r.finished {
+		r.release(value)
+		return
+	}
+
+	r.value = value
+	r.done = true
+}
+
+// Finish is called with the error returned from the client once the call has
+// finished, values set after Finish are discarded.  When err is not nil the
+// kept value is discarded and the zero value is returned.
+func (r *FirstResult[T]) Finish(err error) T {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.finished = true
+
+	var zero T
+	if err != nil {
+		if r.done {
+			r.release(r.value)
+			r.value = zero
+		}
+
+		return zero
+	}
+
+	return r.value
+}
+
+func (r *FirstResult[T]) release(value T) {
+	if r.discard != nil {
+		r.discard(value)
+	}
+}
@@ -0,0 +1,48 @@
+package ultraclient
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstResultKeepsFirstValue(t *testing.T) {
+	var discarded []int
+	result := NewFirstResult(func(value int) { discarded = append(discarded, value) })
+
+	result.Set(1)
+	result.Set(2)
+
+	assert.Equal(t, 1, result.Finish(nil))
+	assert.Equal(t, []int{2}, discarded)
+}
+
+func TestFirstResultDiscardsValuesSetAfterFinish(t *testing.T) {
+	var discarded []int
+	result := NewFirstResult(func(value int) { discarded = append(discarded, value) })
+
+	assert.Equal(t, 0, result.Finish(nil))
+	result.Set(1)
+
+	assert.Equal(t, []int{1}, discarded)
+}
+
+func TestFirstResultDiscardsValueWhenCallFails(t *testing.T) {
+	var discarded []int
+	result := NewFirstResult(func(value int) { discarded = append(discarded, value) })
+
+	result.Set(1)
+
+	assert.Equal(t, 0, result.Finish(errors.New("boom")))
+	assert.Equal(t, []int{1}, discarded)
+}
+
+func TestFirstResultWithoutDiscard(t *testing.T) {
+	result := NewFirstResult[int](nil)
+
+	result.Set(1)
+	result.Set(2)
+
+	assert.Equal(t, 1, result.Finish(nil))
+}
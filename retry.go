@@ -0,0 +1,70 @@
+package ultraclient
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultMaxRetryAfter caps the delay requested by a RetryAfter error when
+// Config.MaxRetryAfter is zero
+const DefaultMaxRetryAfter = 30 * time.Second
+
+// retryPolicy runs the work until it succeeds or the backoff schedule is
+// exhausted, a RetryAfter hint returned from the work replaces the scheduled
+// delay for the next attempt.
+type retryPolicy struct {
+	backoff       []time.Duration
+	maxRetryAfter time.Duration
+}
+
+func newRetryPolicy(backoff []time.Duration, maxRetryAfter time.Duration) *retryPolicy {
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = DefaultMaxRetryAfter
+	}
+
+	return &retryPolicy{
+		backoff:       backoff,
+		maxRetryAfter: maxRetryAfter,
+	}
+}
+
+// run calls the work with the number of the attempt, starting at 1, and the
+// delay waited before the attempt
+func (r *retryPolicy) run(ctx context.Context, work func(attempt int, backoff time.Duration) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	retries := 0
+	var delay time.Duration
+	for {
+		err := work(retries+1, delay)
+		if err == nil || retries >= len(r.backoff) || !isRetryable(err) {
+			return err
+		}
+
+		delay = r.delay(retries, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		retries++
+	}
+}
+
+func (r *retryPolicy) delay(retry int, err error) time.Duration {
+	hint, ok := retryAfterHint(err)
+	if !ok {
+		return r.backoff[retry]
+	}
+
+	if hint > r.maxRetryAfter {
+		return r.maxRetryAfter
+	}
+
+	return hint
+}
@@ -0,0 +1,139 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// SharedWorkFunc defines the work function to be passed to the
+// Client.DoShared method, the returned value is shared with every caller
+// waiting on the work
+type SharedWorkFunc func(ctx context.Context, endpoint url.URL) (interface{}, error)
+
+// DoShared performs the work in the same way as DoContext, concurrent calls
+// with the same key are collapsed into a single execution of the work and
+// every caller receives its result.  Only the work passed by the first caller
+// is executed.  The work runs until it completes even when the callers give
+// up, unless Config.CancelAbandonedSharedWork is set in which case it is
+// cancelled once every caller has gone away.
+//
+//	value, err := client.DoShared(ctx, "user:"+id, func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+//	  return fetchUser(ctx, endpoint, id)
+//	})
+func (c *ClientImpl) DoShared(ctx context.Context, key string, work SharedWorkFunc) (interface{}, error) {
+	return c.doShared(ctx, c.sharedCalls, key, work)
+}
+
+// doShared collapses concurrent calls with the same key in calls into a
+// single execution of the work
+func (c *ClientImpl) doShared(ctx context.Context, calls *sharedCalls, key string, work SharedWorkFunc) (interface{}, error) {
+	call, leader := calls.join(ctx, key)
+
+	if leader {
+		go func() {
+			result := NewFirstResult[interface{}](nil)
+			err := c.DoContext(call.ctx, func(ctx context.Context, endpoint url.URL) error {
+				value, err := work(ctx, endpoint)
+				if err != nil {
+					return err
+				}
+
+				result.Set(value)
+				return nil
+			})
+
+			calls.complete(key, call, result.Finish(err), err)
+		}()
+	} else {
+		c.incrementStats(nil, StatsCoalesced)
+	}
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		calls.leave(key, call, c.settings().config.CancelAbandonedSharedWork)
+		return nil, ctx.Err()
+	}
+}
+
+// sharedCall is a single execution of work which is shared by the callers
+type sharedCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	value   interface{}
+	err     error
+	waiters int
+}
+
+// sharedCalls holds the work in flight for each key, it is shared between a
+// client and its clones
+type sharedCalls struct {
+	sync.Mutex
+	calls map[string]*sharedCall
+}
+
+func newSharedCalls() *sharedCalls {
+	return &sharedCalls{calls: make(map[string]*sharedCall)}
+}
+
+// join adds a caller to the work for the key, leader is true when the caller
+// must start the work
+func (s *sharedCalls) join(ctx context.Context, key string) (call *sharedCall, leader bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if call, ok := s.calls[key]; ok {
+		call.waiters++
+		return call, false
+	}
+
+	// the work must outlive the first caller as other callers may still be
+	// waiting on it
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	call = &sharedCall{
+		ctx:     callCtx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		waiters: 1,
+	}
+	s.calls[key] = call
+
+	return call, true
+}
+
+// leave removes a caller which has given up waiting, when cancel is true and
+// no callers remain the work is cancelled
+func (s *sharedCalls) leave(key string, call *sharedCall, cancel bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 || !cancel {
+		return
+	}
+
+	// new callers must not join work which has been cancelled
+	if s.calls[key] == call {
+		delete(s.calls, key)
+	}
+
+	call.cancel()
+}
+
+func (s *sharedCalls) complete(key string, call *sharedCall, value interface{}, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	call.value = value
+	call.err = err
+	close(call.done)
+	call.cancel()
+
+	if s.calls[key] == call {
+		delete(s.calls, key)
+	}
+}
@@ -0,0 +1,171 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupSharedClient() {
+	setupClient(0)
+
+	// allow the shared work to block while callers join
+	for _, u := range urls {
+		hystrix.ConfigureCommand(u.String(), hystrix.CommandConfig{Timeout: 1000})
+	}
+}
+
+// waitForWaiters waits until the given number of callers have joined the
+// work for the key and returns the work
+func waitForWaiters(key string, waiters int) *sharedCall {
+	for {
+		client.sharedCalls.Lock()
+		call, ok := client.sharedCalls.calls[key]
+		joined := ok && call.waiters >= waiters
+		client.sharedCalls.Unlock()
+
+		if joined {
+			return call
+		}
+
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+// cancelWhenStarted cancels the callers context once the work for the key
+// has started, the work is sent to the returned channel
+func cancelWhenStarted(key string, started <-chan struct{}, cancel context.CancelFunc) <-chan *sharedCall {
+	callChan := make(chan *sharedCall, 1)
+
+	go func() {
+		<-started
+		callChan <- waitForWaiters(key, 1)
+		cancel()
+	}()
+
+	return callChan
+}
+
+func TestDoSharedCollapsesConcurrentCalls(t *testing.T) {
+	setupSharedClient()
+
+	var lock sync.Mutex
+	callCount := 0
+	release := make(chan struct{})
+	work := func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		lock.Lock()
+		callCount++
+		lock.Unlock()
+
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([]interface{}, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = client.DoShared(context.Background(), "key", work)
+		}(i)
+	}
+
+	waitForWaiters("key", 5)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, callCount)
+	assert.Equal(t, []interface{}{"value", "value", "value", "value", "value"}, values)
+	mockStats.AssertNumberOfCalls(t, "Increment", 6) // called, success and 4 coalesced
+	mockStats.AssertCalled(t,
+		"Increment",
+		"myapp.coalesced", client.config.StatsD.Tags, mock.Anything)
+}
+
+func TestDoSharedDoesNotCollapseDifferentKeys(t *testing.T) {
+	setupSharedClient()
+
+	callCount := 0
+	work := func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		callCount++
+		return nil, nil
+	}
+
+	client.DoShared(context.Background(), "key1", work)
+	client.DoShared(context.Background(), "key2", work)
+
+	assert.Equal(t, 2, callCount)
+}
+
+func TestDoSharedContinuesWorkWhenCallersGoAway(t *testing.T) {
+	setupSharedClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan bool, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	callChan := cancelWhenStarted("key", started, cancel)
+
+	_, err := client.DoShared(ctx, "key", func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		close(started)
+
+		select {
+		case <-release:
+			cancelled <- false
+		case <-ctx.Done():
+			cancelled <- true
+		}
+
+		return nil, nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	close(release)
+	assert.False(t, <-cancelled)
+	<-(<-callChan).done
+}
+
+func TestDoSharedCancelsWorkWhenAllCallersGoAway(t *testing.T) {
+	setupSharedClient()
+	client.config.CancelAbandonedSharedWork = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan bool, 1)
+	started := make(chan struct{})
+	callChan := cancelWhenStarted("key", started, cancel)
+
+	_, err := client.DoShared(ctx, "key", func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		close(started)
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+			cancelled <- false
+		case <-ctx.Done():
+			cancelled <- true
+		}
+
+		return nil, ctx.Err()
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, <-cancelled)
+	<-(<-callChan).done
+}
+
+func TestTypedDoSharedReturnsValue(t *testing.T) {
+	setupSharedClient()
+
+	value, err := DoShared(context.Background(), client, "key", func(ctx context.Context, endpoint url.URL) (int, error) {
+		return 42, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 42, value)
+}
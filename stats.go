@@ -13,7 +13,7 @@ const (
 	StatsCalled = "called"
 	// StatsSuccess is a statsD tag to indicate operational success
 	StatsSuccess = "success"
-	// StatsRetry is a statsD tag to indicate the client will retry
+	// StatsRetry is a statsD tag for the count of retries made by a call
 	StatsRetry = "retry"
 	// StatsError is a statsD tag to indicate that the client has resulted in
 	// an error
@@ -23,6 +23,76 @@ const (
 	StatsCircuitOpen = "circuitopen"
 	// StatsTimeout is a statsD tag to indicate that the operation has timed out
 	StatsTimeout = "timeout"
+	// StatsFallbackSuccess is a statsD tag to indicate that the fallback
+	// function succeeded
+	StatsFallbackSuccess = "fallbacksuccess"
+	// StatsFallbackError is a statsD tag to indicate that the fallback
+	// function returned an error
+	StatsFallbackError = "fallbackerror"
+	// StatsMaxConcurrency is a statsD tag to indicate that work was rejected
+	// because too many requests were in flight
+	StatsMaxConcurrency = "maxconcurrency"
+	// StatsCoalesced is a statsD tag to indicate that a call was collapsed
+	// into work already in flight for the same key
+	StatsCoalesced = "coalesced"
+	// StatsCacheHit is a statsD tag to indicate that a fresh value was returned
+	// from the cache
+	StatsCacheHit = "cachehit"
+	// StatsCacheMiss is a statsD tag to indicate that there was no fresh value
+	// in the cache
+	StatsCacheMiss = "cachemiss"
+	// StatsCacheStale is a statsD tag to indicate that a stale value was
+	// returned from the cache because the work failed
+	StatsCacheStale = "cachestale"
+	// StatsRateLimited is a statsD tag to indicate that a request was rejected
+	// by the rate limit
+	StatsRateLimited = "ratelimited"
+	// StatsConcurrencyLimited is a statsD tag to indicate that a request was
+	// rejected by the adaptive concurrency limit
+	StatsConcurrencyLimited = "concurrencylimited"
+	// StatsConcurrencyLimit is a statsD tag for the gauge of the current
+	// adaptive concurrency limit
+	StatsConcurrencyLimit = "concurrencylimit"
+	// StatsLoadShed is a statsD tag to indicate that a request was rejected
+	// because of its priority while the client is under load
+	StatsLoadShed = "loadshed"
+	// StatsAdmitted is a statsD tag to indicate that a request was admitted
+	// by the load shedding policy
+	StatsAdmitted = "admitted"
+	// StatsBulkheadFull is a statsD tag to indicate that a request was
+	// rejected because its bulkhead was full
+	StatsBulkheadFull = "bulkheadfull"
+	// StatsBulkheadWait is a statsD tag for the time a request waited for a
+	// slot in its bulkhead
+	StatsBulkheadWait = "bulkheadwait"
+	// StatsReload is a statsD tag to indicate that the configuration of the
+	// client was reloaded
+	StatsReload = "reload"
+	// StatsReloadError is a statsD tag to indicate that a new configuration
+	// was rejected
+	StatsReloadError = "reloaderror"
+	// StatsDiscoveryUpdate is a statsD tag to indicate that the endpoints
+	// were updated from service discovery
+	StatsDiscoveryUpdate = "discoveryupdate"
+	// StatsDiscoveryFailed is a statsD tag to indicate that service discovery
+	// returned no endpoints and the last good endpoints were kept
+	StatsDiscoveryFailed = "discoveryfailed"
+	// StatsDiscoveryEndpoints is a statsD tag for the gauge of the number of
+	// endpoints returned from service discovery
+	StatsDiscoveryEndpoints = "discoveryendpoints"
+	// StatsInFlight is a statsD tag for the gauge of the number of requests
+	// in flight for the client and its clones
+	StatsInFlight = "inflight"
+	// StatsOpenCircuits is a statsD tag for the gauge of the number of
+	// endpoints with an open circuit, published when the client sees a
+	// circuit open or close
+	StatsOpenCircuits = "opencircuits"
+	// StatsEndpoints is a statsD tag for the gauge of the number of endpoints
+	// available to the loadbalancer
+	StatsEndpoints = "endpoints"
+	// StatsAttempts is a statsD tag for the histogram of the number of
+	// attempts made by a call
+	StatsAttempts = "attempts"
 )
 
 // Stats is an interface which the concrete type will implement in order to send statistics to
@@ -42,6 +112,42 @@ type Stats interface {
 	Timing(name string, tags []string, duration time.Duration, rate float64)
 }
 
+// GaugeStats is implemented by Stats which can also record gauges, the client
+// detects it with a type assertion so implementations of Stats which do not
+// support gauges continue to work
+type GaugeStats interface {
+	Stats
+
+	// Gauge records the current value of a metric
+	// name is the name of the bucket to write to
+	// tags is the list of tags to associate with the metric
+	// value is the current value
+	// rate is the rate to associate with the metric
+	Gauge(name string, tags []string, value float64, rate float64)
+}
+
+// ExtendedStats is implemented by Stats which can record gauges, histograms
+// and counts, like GaugeStats it is detected with a type assertion.  The
+// client records a histogram of the attempts made by each call and a count
+// of the retries.
+type ExtendedStats interface {
+	GaugeStats
+
+	// Histogram records a value in the distribution of a metric
+	// name is the name of the bucket to write to
+	// tags is the list of tags to associate with the metric
+	// value is the value to record
+	// rate is the rate to associate with the metric
+	Histogram(name string, tags []string, value float64, rate float64)
+
+	// Count adds the delta to a counter
+	// name is the name of the bucket to write to
+	// tags is the list of tags to associate with the metric
+	// delta is the amount to add to the counter
+	// rate is the rate to associate with the metric
+	Count(name string, tags []string, delta int64, rate float64)
+}
+
 // MockStats is a mock implementation of the Stats interface to be used
 // for testing
 type MockStats struct {
@@ -57,3 +163,30 @@ func (m *MockStats) Increment(name string, tags []string, rate float64) {
 func (m *MockStats) Timing(name string, tags []string, duration time.Duration, rate float64) {
 	m.Called(name, tags, duration, rate)
 }
+
+// MockGaugeStats is a mock implementation of the GaugeStats interface to be
+// used for testing
+type MockGaugeStats struct {
+	MockStats
+}
+
+// Gauge is a mock implementation of the GaugeStats interface
+func (m *MockGaugeStats) Gauge(name string, tags []string, value float64, rate float64) {
+	m.Called(name, tags, value, rate)
+}
+
+// MockExtendedStats is a mock implementation of the ExtendedStats interface to
+// be used for testing
+type MockExtendedStats struct {
+	MockGaugeStats
+}
+
+// Histogram is a mock implementation of the ExtendedStats interface
+func (m *MockExtendedStats) Histogram(name string, tags []string, value float64, rate float64) {
+	m.Called(name, tags, value, rate)
+}
+
+// Count is a mock implementation of the ExtendedStats interface
+func (m *MockExtendedStats) Count(name string, tags []string, delta int64, rate float64) {
+	m.Called(name, tags, delta, rate)
+}
@@ -26,6 +26,18 @@ type LoadbalancingStrategy interface {
 	Clone() LoadbalancingStrategy
 }
 
+// WeightedLoadbalancingStrategy is implemented by loadbalancing strategies
+// which use the weight, zone and labels given to endpoints by service
+// discovery, the client detects it with a type assertion and passes the
+// metadata separately from the endpoints
+type WeightedLoadbalancingStrategy interface {
+	LoadbalancingStrategy
+
+	// SetWeightedEndpoints sets or updates the endpoints for the strategy with
+	// the metadata of each endpoint in the same order
+	SetWeightedEndpoints(endpoints []url.URL, metadata []EndpointMetadata)
+}
+
 // BackoffStrategy implements a strategy for retry backoffs
 type BackoffStrategy interface {
 	Create(retries int, delay time.Duration) []time.Duration
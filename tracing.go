@@ -0,0 +1,221 @@
+package ultraclient
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+const (
+	// SpanDo is the name of the span which covers a call to Do, DoContext or
+	// DoWithFallback including every attempt
+	SpanDo = "ultraclient.Do"
+	// SpanDoAll is the name of the span which covers a call to DoAll
+	SpanDoAll = "ultraclient.DoAll"
+	// SpanDoQuorum is the name of the span which covers a call to DoQuorum
+	SpanDoQuorum = "ultraclient.DoQuorum"
+	// SpanAttempt is the name of the span which covers a single attempt of the
+	// work against an endpoint
+	SpanAttempt = "ultraclient.attempt"
+)
+
+const (
+	// AttributeEndpoint is the endpoint the attempt was made against
+	AttributeEndpoint = "ultraclient.endpoint"
+	// AttributeAttempt is the number of the attempt starting at 1
+	AttributeAttempt = "ultraclient.attempt"
+	// AttributeAttempts is the number of attempts made by the call
+	AttributeAttempts = "ultraclient.attempts"
+	// AttributeBackoff is the delay in milliseconds waited before the attempt,
+	// recorded as a float64 so delays under a millisecond are kept
+	AttributeBackoff = "ultraclient.backoff_ms"
+	// AttributeBreaker is the state of the circuit breaker for the endpoint
+	// when the attempt started, open or closed
+	AttributeBreaker = "ultraclient.breaker"
+	// AttributeError is the classification of the result, one of success,
+	// timeout, circuit_open, rejected, cancelled, bad_request, non_retryable
+	// or error
+	AttributeError = "ultraclient.error"
+	// AttributeFallback is true when the fallback was called
+	AttributeFallback = "ultraclient.fallback"
+)
+
+// Tracer records a span for each call to the client and a child span for
+// each attempt, the context returned from Start is passed to the work function
+// so the trace is propagated to downstream calls.  Tracing libraries such as
+// OpenTelemetry do not implement Tracer, a small adapter converts the spans
+// and attributes, the README has an example
+type Tracer interface {
+	// Start starts a span which is a child of any span in the context
+	// name is the name of the span
+	// attributes are the attributes known when the span starts
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	// SetAttributes adds the attributes to the span
+	SetAttributes(attributes ...Attribute)
+
+	// RecordError records the error returned from the span
+	RecordError(err error)
+
+	// End completes the span
+	End()
+}
+
+// Attribute is a key value pair attached to a span, values are a string, int,
+// float64 or bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// RegisterTracer sets the tracer used by the client and its clones created
+// after the call, spans are not recorded until a tracer is registered
+func (c *ClientImpl) RegisterTracer(tracer Tracer) {
+	c.tracer = tracer
+}
+
+// startSpan starts a span for a call to the client
+func (c *ClientImpl) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return c.tracer.Start(ctx, name)
+}
+
+// startAttempt starts a span for an attempt of the work against the endpoint,
+// the state of the breaker is only read when a tracer is registered
+func (c *ClientImpl) startAttempt(
+	ctx context.Context,
+	endpoint *url.URL,
+	attempt int,
+	backoff time.Duration) (context.Context, *attemptSpan) {
+
+	if c.tracer == nil {
+		return ctx, &attemptSpan{span: noopSpan{}}
+	}
+
+	ctx, span := c.tracer.Start(ctx, SpanAttempt,
+		Attribute{Key: AttributeEndpoint, Value: PrettyPrintURL(endpoint)},
+		Attribute{Key: AttributeAttempt, Value: attempt},
+		Attribute{Key: AttributeBackoff, Value: float64(backoff) / float64(time.Millisecond)},
+		Attribute{Key: AttributeBreaker, Value: breakerState(endpoint)},
+	)
+
+	return ctx, &attemptSpan{span: span}
+}
+
+// attemptSpan ends the span of an attempt once the client has the result of
+// the attempt and the work has returned, when hystrix times out the work it
+// is left running with the context of the span
+type attemptSpan struct {
+	sync.Mutex
+	span     Span
+	err      error
+	working  bool
+	finished bool
+}
+
+// workStarted is called before the work is performed
+func (a *attemptSpan) workStarted() {
+	a.Lock()
+	defer a.Unlock()
+
+	a.working = true
+}
+
+// workDone is called when the work returns
+func (a *attemptSpan) workDone() {
+	a.Lock()
+	defer a.Unlock()
+
+	a.working = false
+	a.end()
+}
+
+// finish is called with the result of the attempt
+func (a *attemptSpan) finish(err error) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.finished = true
+	a.err = err
+	a.end()
+}
+
+// end ends the span when both the result and the work are done, the caller
+// must hold the lock
+func (a *attemptSpan) end() {
+	if !a.finished || a.working || a.span == nil {
+		return
+	}
+
+	endSpan(a.span, a.err)
+	a.span = nil
+}
+
+// endSpan records the result of the work and ends the span
+func endSpan(span Span, err error, attributes ...Attribute) {
+	span.SetAttributes(append(attributes, Attribute{Key: AttributeError, Value: classifyError(err)})...)
+
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
+
+func breakerState(endpoint *url.URL) string {
+	circuit, _, err := hystrix.GetCircuit(endpoint.String())
+	if err == nil && circuit.IsOpen() {
+		return "open"
+	}
+
+	return "closed"
+}
+
+// classifyError returns the classification of the error recorded on spans
+func classifyError(err error) string {
+	if err == nil {
+		return "success"
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "cancelled"
+	}
+
+	var clientErr ClientError
+	if errors.As(err, &clientErr) {
+		switch clientErr.Message {
+		case ErrorTimeout:
+			return "timeout"
+		case ErrorCircuitOpen:
+			return "circuit_open"
+		case ErrorRateLimited, ErrorConcurrencyLimited, ErrorLoadShed, ErrorBulkheadFull, ErrorMaxConcurrency:
+			return "rejected"
+		}
+	}
+
+	switch {
+	case isBadRequest(err):
+		return "bad_request"
+	case !isRetryable(err):
+		return "non_retryable"
+	default:
+		return "error"
+	}
+}
+
+// noopSpan is used when no tracer is registered
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attributes ...Attribute) {}
+func (noopSpan) RecordError(err error)                 {}
+func (noopSpan) End()                                  {}
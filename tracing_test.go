@@ -0,0 +1,229 @@
+package ultraclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type spanKey struct{}
+
+// testSpan records the attributes and error of a span
+type testSpan struct {
+	sync.Mutex
+	name       string
+	parent     *testSpan
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *testSpan) SetAttributes(attributes ...Attribute) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, a := range attributes {
+		s.attributes[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) RecordError(err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.err = err
+}
+
+func (s *testSpan) End() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.ended = true
+}
+
+func (s *testSpan) isEnded() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.ended
+}
+
+// testTracer records every span started
+type testTracer struct {
+	sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	t.Lock()
+	defer t.Unlock()
+
+	parent, _ := ctx.Value(spanKey{}).(*testSpan)
+	span := &testSpan{name: name, parent: parent, attributes: map[string]interface{}{}}
+	span.SetAttributes(attributes...)
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *testTracer) named(name string) []*testSpan {
+	t.Lock()
+	defer t.Unlock()
+
+	var spans []*testSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+
+	return spans
+}
+
+func setupTracedClient(retryCount int) *testTracer {
+	setupClient(retryCount)
+
+	tracer := &testTracer{}
+	client.RegisterTracer(tracer)
+
+	return tracer
+}
+
+func TestDoContextRecordsSpanForCall(t *testing.T) {
+	tracer := setupTracedClient(0)
+
+	err := client.DoContext(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		return nil
+	})
+
+	assert.Nil(t, err)
+	spans := tracer.named(SpanDo)
+	assert.Len(t, spans, 1)
+	assert.True(t, spans[0].ended)
+	assert.Equal(t, 1, spans[0].attributes[AttributeAttempts])
+	assert.Equal(t, "success", spans[0].attributes[AttributeError])
+}
+
+func TestDoContextRecordsChildSpanForEachAttempt(t *testing.T) {
+	tracer := setupTracedClient(1)
+
+	err := client.DoContext(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		return fmt.Errorf("boom")
+	})
+
+	assert.NotNil(t, err)
+
+	parent := tracer.named(SpanDo)[0]
+	assert.Equal(t, 2, parent.attributes[AttributeAttempts])
+	assert.Equal(t, "error", parent.attributes[AttributeError])
+	assert.Equal(t, err, parent.err)
+
+	attempts := tracer.named(SpanAttempt)
+	assert.Len(t, attempts, 2)
+
+	for i, attempt := range attempts {
+		assert.Equal(t, parent, attempt.parent)
+		assert.True(t, attempt.ended)
+		assert.Equal(t, i+1, attempt.attributes[AttributeAttempt])
+		assert.Equal(t, PrettyPrintURL(&urls[i]), attempt.attributes[AttributeEndpoint])
+		assert.Equal(t, "closed", attempt.attributes[AttributeBreaker])
+		assert.Equal(t, "error", attempt.attributes[AttributeError])
+		assert.NotNil(t, attempt.err)
+	}
+
+	assert.Equal(t, float64(0), attempts[0].attributes[AttributeBackoff])
+	assert.Equal(t, float64(1), attempts[1].attributes[AttributeBackoff])
+}
+
+func TestDoContextPassesAttemptContextToWork(t *testing.T) {
+	tracer := setupTracedClient(0)
+
+	var span *testSpan
+	client.DoContext(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		span, _ = ctx.Value(spanKey{}).(*testSpan)
+		return nil
+	})
+
+	assert.Equal(t, tracer.named(SpanAttempt)[0], span)
+}
+
+func TestDoContextEndsAttemptSpanWhenTimedOutWorkReturns(t *testing.T) {
+	tracer := setupTracedClient(0)
+
+	done := make(chan struct{})
+	client.DoContext(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		<-done
+		return nil
+	})
+
+	attempt := tracer.named(SpanAttempt)[0]
+	assert.False(t, attempt.isEnded())
+
+	close(done)
+	for i := 0; i < 100 && !attempt.isEnded(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.True(t, attempt.isEnded())
+	assert.Equal(t, "timeout", attempt.attributes[AttributeError])
+}
+
+func TestDoWithFallbackRecordsFallbackOnSpan(t *testing.T) {
+	tracer := setupTracedClient(0)
+
+	err := client.DoWithFallback(func(endpoint url.URL) error {
+		return fmt.Errorf("boom")
+	}, func(err error) error {
+		return nil
+	})
+
+	assert.Nil(t, err)
+	span := tracer.named(SpanDo)[0]
+	assert.Equal(t, true, span.attributes[AttributeFallback])
+	assert.Equal(t, "success", span.attributes[AttributeError])
+}
+
+func TestDoAllRecordsAttemptForEachEndpoint(t *testing.T) {
+	tracer := setupTracedClient(0)
+
+	client.DoAll(context.Background(), func(ctx context.Context, endpoint url.URL) error {
+		return nil
+	})
+
+	parent := tracer.named(SpanDoAll)[0]
+	attempts := tracer.named(SpanAttempt)
+	assert.Len(t, attempts, len(urls))
+	for _, attempt := range attempts {
+		assert.Equal(t, parent, attempt.parent)
+	}
+}
+
+func TestCloneUsesTracer(t *testing.T) {
+	tracer := setupTracedClient(0)
+
+	clone := client.Clone().(*ClientImpl)
+
+	assert.Equal(t, tracer, clone.tracer)
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := map[string]error{
+		"success":       nil,
+		"cancelled":     ClientError{Message: "context canceled", Err: context.Canceled},
+		"timeout":       ClientError{Message: ErrorTimeout},
+		"circuit_open":  ClientError{Message: ErrorCircuitOpen},
+		"rejected":      ClientError{Message: ErrorRateLimited, Err: NonRetryable(errRateLimited)},
+		"bad_request":   ClientError{Message: "missing", Err: BadRequest(fmt.Errorf("missing"))},
+		"non_retryable": ClientError{Message: "invalid", Err: NonRetryable(fmt.Errorf("invalid"))},
+		"error":         fmt.Errorf("boom"),
+	}
+
+	for expected, err := range tests {
+		assert.Equal(t, expected, classifyError(err))
+	}
+}
@@ -0,0 +1,133 @@
+package ultraclient
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+)
+
+// TypedWorkFunc defines a work function which returns a value, it is passed
+// to the Do and DoWithFallback functions
+type TypedWorkFunc[T any] func(ctx context.Context, endpoint url.URL) (T, error)
+
+// TypedFallbackFunc defines a function which returns a value when the work
+// could not be completed, err is the error returned from the client
+type TypedFallbackFunc[T any] func(ctx context.Context, err error) (T, error)
+
+// Do performs the work with the given client and returns the value from the
+// first successful attempt, the value is returned without the work function
+// having to set a shared variable.
+// user, err := ultraclient.Do(ctx, client, func(ctx context.Context, endpoint url.URL) (User, error) {
+//   return fetchUser(ctx, endpoint, id)
+// })
+func Do[T any](ctx context.Context, client Client, work TypedWorkFunc[T]) (T, error) {
+	result := NewFirstResult[T](nil)
+
+	err := client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		value, err := work(ctx, endpoint)
+		if err != nil {
+			return err
+		}
+
+		result.Set(value)
+		return nil
+	})
+
+	return result.Finish(err), err
+}
+
+// DoWithFallback performs the work in the same way as Do, when the work can
+// not be completed the value returned from the fallback is returned instead.
+// The fallback is called in the same way as Client.DoWithFallback.
+func DoWithFallback[T any](
+	ctx context.Context,
+	client Client,
+	work TypedWorkFunc[T],
+	fallback TypedFallbackFunc[T]) (T, error) {
+
+	result := NewFirstResult[T](nil)
+
+	var fallbackValue T
+	usedFallback := false
+
+	err := client.DoContextWithFallback(ctx, func(ctx context.Context, endpoint url.URL) error {
+		value, err := work(ctx, endpoint)
+		if err != nil {
+			return err
+		}
+
+		result.Set(value)
+		return nil
+	}, func(err error) error {
+		value, err := fallback(ctx, err)
+		if err != nil {
+			return err
+		}
+
+		fallbackValue = value
+		usedFallback = true
+		return nil
+	})
+
+	value := result.Finish(err)
+	switch {
+	case err != nil:
+		return value, err
+	case usedFallback:
+		return fallbackValue, nil
+	default:
+		return value, nil
+	}
+}
+
+// DoShared performs the work in the same way as Client.DoShared, concurrent
+// calls with the same key are collapsed into a single execution of the work
+// and every caller receives the value.  A TypeMismatchError is returned when
+// the value for the key is not a T.
+// user, err := ultraclient.DoShared(ctx, client, "user:"+id, func(ctx context.Context, endpoint url.URL) (User, error) {
+//   return fetchUser(ctx, endpoint, id)
+// })
+func DoShared[T any](ctx context.Context, client Client, key string, work TypedWorkFunc[T]) (T, error) {
+	value, err := client.DoShared(ctx, key, func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		return work(ctx, endpoint)
+	})
+
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return typedValue[T](key, value)
+}
+
+// DoCached performs the work in the same way as Client.DoCached, the value is
+// returned from the cache while it is fresh or when the work fails and the
+// cached value is within the stale window.  A TypeMismatchError is returned
+// when the value for the key is not a T.
+func DoCached[T any](ctx context.Context, client Client, key string, work TypedWorkFunc[T]) (T, error) {
+	value, err := client.DoCached(ctx, key, func(ctx context.Context, endpoint url.URL) (interface{}, error) {
+		return work(ctx, endpoint)
+	})
+
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return typedValue[T](key, value)
+}
+
+// typedValue returns the value as a T, a nil value is the zero T
+func typedValue[T any](key string, value interface{}) (T, error) {
+	var zero T
+	if value == nil {
+		return zero, nil
+	}
+
+	typed, ok := value.(T)
+	if !ok {
+		return zero, TypeMismatchError{Key: key, Value: value, Type: reflect.TypeOf(&zero).Elem()}
+	}
+
+	return typed, nil
+}
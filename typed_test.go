@@ -0,0 +1,87 @@
+package ultraclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedDoReturnsValue(t *testing.T) {
+	setupClient(0)
+
+	value, err := Do(context.Background(), client, func(ctx context.Context, endpoint url.URL) (string, error) {
+		return endpoint.Host, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "something:3232", value)
+}
+
+func TestTypedDoReturnsValueFromSuccessfulAttempt(t *testing.T) {
+	setupClient(1)
+
+	value, err := Do(context.Background(), client, func(ctx context.Context, endpoint url.URL) (string, error) {
+		if endpoint.Host == "something:3232" {
+			return "failed", fmt.Errorf("aaah")
+		}
+
+		return endpoint.Host, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "somethingelse:2323", value)
+}
+
+func TestTypedDoReturnsZeroValueOnError(t *testing.T) {
+	setupClient(0)
+
+	value, err := Do(context.Background(), client, func(ctx context.Context, endpoint url.URL) (int, error) {
+		return 10, fmt.Errorf("aaah")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, value)
+}
+
+func TestTypedDoWithFallbackReturnsFallbackValueOnError(t *testing.T) {
+	setupClient(0)
+
+	var fallbackErr error
+	value, err := DoWithFallback(
+		context.Background(),
+		client,
+		func(ctx context.Context, endpoint url.URL) (string, error) {
+			return "", fmt.Errorf("aaah")
+		},
+		func(ctx context.Context, err error) (string, error) {
+			fallbackErr = err
+			return "cached", nil
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", value)
+	assert.NotNil(t, fallbackErr)
+}
+
+func TestTypedDoWithFallbackDoesNotCallFallbackOnSuccess(t *testing.T) {
+	setupClient(0)
+
+	value, err := DoWithFallback(
+		context.Background(),
+		client,
+		func(ctx context.Context, endpoint url.URL) (string, error) {
+			return "live", nil
+		},
+		func(ctx context.Context, err error) (string, error) {
+			t.Fatal("fallback should not be called")
+			return "", nil
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "live", value)
+}
@@ -0,0 +1,203 @@
+package ultradiscovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+)
+
+// Consul is a Discoverer which tracks the passing instances of a service
+// with the Consul health API, blocking queries are used so changes are seen
+// as soon as Consul makes them.
+type Consul struct {
+	// Address is the address of the Consul HTTP API
+	Address string
+
+	// Service is the name of the service
+	Service string
+
+	// Tag filters the instances to those with the tag
+	Tag string
+
+	// Datacenter is the datacenter to query, the datacenter of the agent is
+	// used when empty
+	Datacenter string
+
+	// Token is the ACL token sent with each request
+	Token string
+
+	// Scheme is the scheme given to each endpoint
+	Scheme string
+
+	// ZoneMetaKey and WeightMetaKey are the node metadata keys which give the
+	// zone and weight of the endpoints, when the node has no weight the
+	// passing weight of the service is used
+	ZoneMetaKey   string
+	WeightMetaKey string
+
+	// WaitTime is the maximum time a blocking query waits for a change
+	WaitTime time.Duration
+
+	// RetryInterval is the time to wait before querying again after a
+	// failure, an interval which is not greater than zero is replaced with
+	// DefaultConsulRetryInterval
+	RetryInterval time.Duration
+
+	// Client is the http client used to query Consul
+	Client *http.Client
+}
+
+// NewConsul creates a Discoverer for the service registered with Consul
+// client.Discover(ctx, ultradiscovery.NewConsul("http://127.0.0.1:8500", "myservice"), 5*time.Second)
+func NewConsul(address, service string) *Consul {
+	return &Consul{
+		Address:       address,
+		Service:       service,
+		ZoneMetaKey:   "zone",
+		WeightMetaKey: "weight",
+		WaitTime:      5 * time.Minute,
+		RetryInterval: DefaultConsulRetryInterval,
+		Client:        http.DefaultClient,
+	}
+}
+
+// DefaultConsulRetryInterval is the RetryInterval a Consul uses when it is
+// not given one
+const DefaultConsulRetryInterval = 1 * time.Second
+
+// consulEntry is an entry returned from /v1/health/service
+type consulEntry struct {
+	Node struct {
+		Address string
+		Meta    map[string]string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// Watch implements the ultraclient.Discoverer interface, the endpoints are
+// sent when they change and an empty set is sent when Consul can not be
+// queried
+func (c *Consul) Watch(ctx context.Context) <-chan []ultraclient.Endpoint {
+	var index uint64
+
+	retryInterval := c.retryInterval()
+
+	return poll(ctx, func(ctx context.Context) ([]ultraclient.Endpoint, time.Duration, error) {
+		endpoints, next, err := c.query(ctx, index)
+		if err != nil {
+			index = 0
+			return nil, retryInterval, err
+		}
+
+		// the index is reset when it goes backwards so the next query returns
+		// immediately rather than blocking on an index which may never come
+		if next < index {
+			next = 0
+		}
+		index = next
+
+		// without an index the query does not block so it is rate limited
+		if index == 0 {
+			return endpoints, retryInterval, nil
+		}
+
+		return endpoints, 0, nil
+	})
+}
+
+// retryInterval returns the time to wait before querying again after a
+// failure
+func (c *Consul) retryInterval() time.Duration {
+	if c.RetryInterval <= 0 {
+		return DefaultConsulRetryInterval
+	}
+
+	return c.RetryInterval
+}
+
+func (c *Consul) query(ctx context.Context, index uint64) ([]ultraclient.Endpoint, uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	if c.Tag != "" {
+		query.Set("tag", c.Tag)
+	}
+	if c.Datacenter != "" {
+		query.Set("dc", c.Datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%vs", int(c.WaitTime.Seconds())))
+	}
+
+	u := strings.TrimSuffix(c.Address, "/") + "/v1/health/service/" + url.PathEscape(c.Service) + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned status: %v", resp.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	endpoints := make([]ultraclient.Endpoint, 0, len(entries))
+	for _, entry := range entries {
+		endpoints = append(endpoints, c.endpoint(entry))
+	}
+
+	sortEndpoints(endpoints)
+	return endpoints, next, nil
+}
+
+func (c *Consul) endpoint(entry consulEntry) ultraclient.Endpoint {
+	address := entry.Service.Address
+	if address == "" {
+		address = entry.Node.Address
+	}
+
+	metadata := ultraclient.EndpointMetadata{Weight: 1, Zone: entry.Node.Meta[c.ZoneMetaKey]}
+	if weight, err := strconv.Atoi(entry.Node.Meta[c.WeightMetaKey]); err == nil && weight >= 0 {
+		metadata.Weight = weight
+	} else if entry.Service.Weights.Passing > 0 {
+		metadata.Weight = entry.Service.Weights.Passing
+	}
+
+	return ultraclient.Endpoint{
+		URL: url.URL{
+			Scheme: c.Scheme,
+			Host:   net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)),
+		},
+		Metadata: metadata,
+	}
+}
@@ -0,0 +1,156 @@
+package ultradiscovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// consulServer is a stand in for the Consul health API, blocking queries
+// wait until the index changes
+type consulServer struct {
+	sync.Mutex
+	index    uint64
+	entries  string
+	changed  chan struct{}
+	requests []*http.Request
+}
+
+func (c *consulServer) set(entries string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.index++
+	c.entries = entries
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *consulServer) lastRequest() *http.Request {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.requests[len(c.requests)-1]
+}
+
+// blockedOn returns true when a request has been made for the index
+func (c *consulServer) blockedOn(index string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, r := range c.requests {
+		if r.URL.Query().Get("index") == index {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *consulServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	c.requests = append(c.requests, r)
+	index, changed := c.index, c.changed
+	c.Unlock()
+
+	if r.URL.Query().Get("index") == strconv.FormatUint(index, 10) {
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	rw.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	rw.Write([]byte(c.entries))
+}
+
+func setupConsul(t *testing.T, entries string) (*consulServer, *Consul) {
+	server := &consulServer{index: 1, entries: entries, changed: make(chan struct{})}
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	consul := NewConsul(httpServer.URL, "myservice")
+	consul.RetryInterval = 1 * time.Millisecond
+
+	return server, consul
+}
+
+func watchConsul(t *testing.T, consul *Consul) <-chan []ultraclient.Endpoint {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return consul.Watch(ctx)
+}
+
+const consulEntries = `[
+  {
+    "Node": {"Address": "10.0.0.1", "Meta": {"zone": "eu-west-1a", "weight": "5"}},
+    "Service": {"Address": "", "Port": 8080, "Weights": {"Passing": 1}}
+  },
+  {
+    "Node": {"Address": "10.0.0.2", "Meta": {}},
+    "Service": {"Address": "10.0.1.2", "Port": 8081, "Weights": {"Passing": 3}}
+  }
+]`
+
+func TestConsulDiscoversPassingInstances(t *testing.T) {
+	server, consul := setupConsul(t, consulEntries)
+	consul.Tag = "v2"
+	consul.Datacenter = "dc2"
+	consul.Token = "secret"
+
+	updates := watchConsul(t, consul)
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 5, Zone: "eu-west-1a"}},
+		{URL: url.URL{Host: "10.0.1.2:8081"}, Metadata: ultraclient.EndpointMetadata{Weight: 3}},
+	}, <-updates)
+
+	req := server.lastRequest()
+	assert.Equal(t, "/v1/health/service/myservice", req.URL.Path)
+	assert.Equal(t, "true", req.URL.Query().Get("passing"))
+	assert.Equal(t, "v2", req.URL.Query().Get("tag"))
+	assert.Equal(t, "dc2", req.URL.Query().Get("dc"))
+	assert.Equal(t, "secret", req.Header.Get("X-Consul-Token"))
+}
+
+func TestConsulUsesBlockingQueriesForChanges(t *testing.T) {
+	server, consul := setupConsul(t, consulEntries)
+
+	updates := watchConsul(t, consul)
+	<-updates
+
+	server.set(`[{"Node": {"Address": "10.0.0.3"}, "Service": {"Port": 8080}}]`)
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.3:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+	assert.True(t, server.blockedOn("1"))
+}
+
+func TestConsulSendsEmptyEndpointsWhenQueryFails(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer httpServer.Close()
+
+	consul := NewConsul(httpServer.URL, "myservice")
+
+	assert.Empty(t, <-watchConsul(t, consul))
+}
+
+func TestConsulDefaultsRetryInterval(t *testing.T) {
+	assert.Equal(t, DefaultConsulRetryInterval, (&Consul{}).retryInterval())
+	assert.Equal(t, 5*time.Second, (&Consul{RetryInterval: 5 * time.Second}).retryInterval())
+}
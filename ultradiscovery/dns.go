@@ -0,0 +1,162 @@
+// Package ultradiscovery provides service discovery for an ultraclient.Client,
+// each Discoverer watches a source of endpoints and is subscribed to a client
+// with Client.Discover.
+package ultradiscovery
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+)
+
+// DNS is a Discoverer which finds endpoints from the A and AAAA records or the
+// SRV records of a name, the records are looked up again when their TTL
+// expires.
+type DNS struct {
+	// Name is the host name to look up, or the SRV name such as
+	// _http._tcp.myservice.example.com when SRV is set
+	Name string
+
+	// Port is the port of the endpoints found from A and AAAA records, SRV
+	// records give their own port
+	Port int
+
+	// SRV looks up SRV records rather than A and AAAA records, only the
+	// records with the lowest priority are used and their weight is given to
+	// the endpoints
+	SRV bool
+
+	// Scheme is the scheme given to each endpoint
+	Scheme string
+
+	// MinInterval and MaxInterval bound the time between lookups, within the
+	// bounds the lowest TTL of the records is used.  Failed lookups are retried
+	// after MinInterval, a MinInterval which is not greater than zero is
+	// replaced with DefaultDNSMinInterval.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// Resolver performs the lookups
+	Resolver Resolver
+}
+
+// DefaultDNSMinInterval is the MinInterval a DNS uses when it is not given
+// one
+const DefaultDNSMinInterval = 1 * time.Second
+
+// NewDNS creates a Discoverer for the A and AAAA records of the host name
+// discoverer := ultradiscovery.NewDNS("myservice.example.com", 8080)
+// client.Discover(ctx, discoverer, 5*time.Second)
+func NewDNS(name string, port int) *DNS {
+	return &DNS{
+		Name:        name,
+		Port:        port,
+		MinInterval: DefaultDNSMinInterval,
+		MaxInterval: 30 * time.Second,
+		Resolver:    NewDNSResolver(),
+	}
+}
+
+// NewDNSSRV creates a Discoverer for the SRV records of the name
+func NewDNSSRV(name string) *DNS {
+	d := NewDNS(name, 0)
+	d.SRV = true
+
+	return d
+}
+
+// Watch implements the ultraclient.Discoverer interface, the endpoints are
+// sent when they change and an empty set is sent when a lookup fails
+func (d *DNS) Watch(ctx context.Context) <-chan []ultraclient.Endpoint {
+	return poll(ctx, func(ctx context.Context) ([]ultraclient.Endpoint, time.Duration, error) {
+		endpoints, ttl, err := d.lookup(ctx)
+		return endpoints, d.interval(ttl, err), err
+	})
+}
+
+// lookup returns the endpoints sorted by host and the lowest TTL of the
+// records
+func (d *DNS) lookup(ctx context.Context) ([]ultraclient.Endpoint, time.Duration, error) {
+	var records []Record
+	var err error
+
+	if d.SRV {
+		records, err = d.Resolver.LookupSRV(ctx, d.Name)
+		records = lowestPriority(records)
+	} else {
+		records, err = d.Resolver.LookupHost(ctx, d.Name)
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ttl time.Duration
+	endpoints := make([]ultraclient.Endpoint, 0, len(records))
+	for i, record := range records {
+		if i == 0 || record.TTL < ttl {
+			ttl = record.TTL
+		}
+
+		endpoints = append(endpoints, d.endpoint(record))
+	}
+
+	sortEndpoints(endpoints)
+
+	return endpoints, ttl, nil
+}
+
+func (d *DNS) endpoint(record Record) ultraclient.Endpoint {
+	target := strings.TrimSuffix(record.Target, ".")
+
+	if !d.SRV {
+		return ultraclient.Endpoint{
+			URL:      url.URL{Scheme: d.Scheme, Host: net.JoinHostPort(target, strconv.Itoa(d.Port))},
+			Metadata: ultraclient.EndpointMetadata{Weight: 1},
+		}
+	}
+
+	return ultraclient.Endpoint{
+		URL:      url.URL{Scheme: d.Scheme, Host: net.JoinHostPort(target, strconv.Itoa(int(record.Port)))},
+		Metadata: ultraclient.EndpointMetadata{Weight: int(record.Weight)},
+	}
+}
+
+// interval returns the time to wait before the next lookup
+func (d *DNS) interval(ttl time.Duration, err error) time.Duration {
+	minInterval := d.MinInterval
+	if minInterval <= 0 {
+		minInterval = DefaultDNSMinInterval
+	}
+
+	if err != nil || ttl < minInterval {
+		return minInterval
+	}
+
+	if d.MaxInterval > 0 && ttl > d.MaxInterval {
+		return d.MaxInterval
+	}
+
+	return ttl
+}
+
+// lowestPriority returns the SRV records with the lowest priority, clients
+// must use these before any other records
+func lowestPriority(records []Record) []Record {
+	var lowest []Record
+	for _, record := range records {
+		switch {
+		case len(lowest) == 0 || record.Priority < lowest[0].Priority:
+			lowest = []Record{record}
+		case record.Priority == lowest[0].Priority:
+			lowest = append(lowest, record)
+		}
+	}
+
+	return lowest
+}
@@ -0,0 +1,203 @@
+package ultradiscovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type fakeResolver struct {
+	sync.Mutex
+	records []Record
+	err     error
+	names   []string
+}
+
+func (f *fakeResolver) lookedUp() []string {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.names
+}
+
+func (f *fakeResolver) set(records []Record, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.records = records
+	f.err = err
+}
+
+func (f *fakeResolver) LookupHost(ctx context.Context, name string) ([]Record, error) {
+	return f.lookup(name)
+}
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, name string) ([]Record, error) {
+	return f.lookup(name)
+}
+
+func (f *fakeResolver) lookup(name string) ([]Record, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.names = append(f.names, name)
+	return f.records, f.err
+}
+
+func setupDNS(t *testing.T, d *DNS, resolver *fakeResolver) <-chan []ultraclient.Endpoint {
+	d.Resolver = resolver
+	d.MinInterval = 1 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return d.Watch(ctx)
+}
+
+func TestDNSDiscoversHostRecords(t *testing.T) {
+	resolver := &fakeResolver{records: []Record{{Target: "10.0.0.2"}, {Target: "10.0.0.1"}, {Target: "::1"}}}
+	d := NewDNS("myservice.example.com", 8080)
+	d.Scheme = "http"
+
+	updates := setupDNS(t, d, resolver)
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Scheme: "http", Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+		{URL: url.URL{Scheme: "http", Host: "10.0.0.2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+		{URL: url.URL{Scheme: "http", Host: "[::1]:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+	}, <-updates)
+	assert.Equal(t, "myservice.example.com", resolver.lookedUp()[0])
+}
+
+func TestDNSDiscoversLowestPrioritySRVRecordsWithWeights(t *testing.T) {
+	resolver := &fakeResolver{records: []Record{
+		{Target: "a.example.com.", Port: 8080, Priority: 10, Weight: 60},
+		{Target: "b.example.com.", Port: 8081, Priority: 10, Weight: 40},
+		{Target: "backup.example.com.", Port: 8080, Priority: 20, Weight: 100},
+	}}
+
+	updates := setupDNS(t, NewDNSSRV("_http._tcp.myservice.example.com"), resolver)
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Host: "a.example.com:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 60}},
+		{URL: url.URL{Host: "b.example.com:8081"}, Metadata: ultraclient.EndpointMetadata{Weight: 40}},
+	}, <-updates)
+}
+
+func TestDNSSendsEmptyEndpointsWhenLookupFails(t *testing.T) {
+	resolver := &fakeResolver{err: errors.New("no such host")}
+
+	updates := setupDNS(t, NewDNS("myservice.example.com", 8080), resolver)
+
+	assert.Empty(t, <-updates)
+}
+
+func TestDNSSendsEndpointsOnlyWhenTheyChange(t *testing.T) {
+	resolver := &fakeResolver{records: []Record{{Target: "10.0.0.1"}}}
+
+	updates := setupDNS(t, NewDNS("myservice.example.com", 8080), resolver)
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+
+	resolver.set([]Record{{Target: "10.0.0.2"}}, nil)
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+}
+
+func TestDNSUsesTTLWithinIntervalBounds(t *testing.T) {
+	d := &DNS{MinInterval: 1 * time.Second, MaxInterval: 30 * time.Second}
+
+	assert.Equal(t, 10*time.Second, d.interval(10*time.Second, nil))
+	assert.Equal(t, 1*time.Second, d.interval(0, nil))
+	assert.Equal(t, 30*time.Second, d.interval(1*time.Hour, nil))
+	assert.Equal(t, 1*time.Second, d.interval(10*time.Second, errors.New("failed")))
+}
+
+func TestDNSDefaultsMinInterval(t *testing.T) {
+	d := &DNS{}
+
+	assert.Equal(t, DefaultDNSMinInterval, d.interval(0, nil))
+	assert.Equal(t, DefaultDNSMinInterval, d.interval(0, errors.New("failed")))
+}
+
+// setupDNSServer starts a DNS server which answers every question with the
+// given resources
+func setupDNSServer(t *testing.T, answers func(q dnsmessage.Question) []dnsmessage.Resource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			query := dnsmessage.Message{}
+			if query.Unpack(buf[:n]) != nil {
+				continue
+			}
+
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true},
+				Questions: query.Questions,
+				Answers:   answers(query.Questions[0]),
+			}
+
+			packed, _ := response.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolverReturnsRecordsWithTTL(t *testing.T) {
+	server := setupDNSServer(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 30}
+
+		switch q.Type {
+		case dnsmessage.TypeA:
+			return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}}}
+		case dnsmessage.TypeSRV:
+			target := dnsmessage.MustNewName("a.example.com.")
+			return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.SRVResource{
+				Priority: 10, Weight: 60, Port: 8080, Target: target,
+			}}}
+		default:
+			return nil
+		}
+	})
+
+	resolver := NewDNSResolver(server)
+
+	hosts, err := resolver.LookupHost(context.Background(), "myservice.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{Target: "10.0.0.1", TTL: 30 * time.Second}}, hosts)
+
+	srv, err := resolver.LookupSRV(context.Background(), "_http._tcp.myservice.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{Target: "a.example.com.", Port: 8080, Priority: 10, Weight: 60, TTL: 30 * time.Second}}, srv)
+}
+
+func TestDNSResolverReturnsErrorWhenServerDoesNotAnswer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	resolver := NewDNSResolver(conn.LocalAddr().String())
+	resolver.Timeout = 10 * time.Millisecond
+
+	_, err = resolver.LookupSRV(context.Background(), "_http._tcp.myservice.example.com")
+
+	assert.NotNil(t, err)
+}
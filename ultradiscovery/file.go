@@ -0,0 +1,231 @@
+package ultradiscovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a Discoverer which reads the endpoints from a file, the file is
+// read again when it changes.  The format is chosen from the extension of the
+// file, .json and .yaml or .yml files contain a list of endpoints, each as an
+// address or as an object with an address and optional weight, zone and
+// metadata.
+//
+//	- server1:8080
+//	- address: http://server2:8080
+//	  weight: 10
+//	  zone: eu-west-1a
+//	  metadata:
+//	    version: v2
+//
+// Any other file has an endpoint on each line with optional key=value
+// metadata, weight and zone are read from the metadata.  Lines starting with
+// # are comments.
+//
+//	server1:8080
+//	http://server2:8080 weight=10 zone=eu-west-1a version=v2
+type File struct {
+	// Path is the path of the file
+	Path string
+
+	// Interval is the time between checks for changes to the file, an
+	// interval which is not greater than zero is replaced with
+	// DefaultFileInterval
+	Interval time.Duration
+}
+
+// DefaultFileInterval is the interval a File uses when it is not given one
+const DefaultFileInterval = 1 * time.Second
+
+// NewFile creates a Discoverer for the endpoints in the file
+// client.Discover(ctx, ultradiscovery.NewFile("/etc/myservice/backends.yaml"), 5*time.Second)
+func NewFile(path string) *File {
+	return &File{Path: path, Interval: DefaultFileInterval}
+}
+
+// Watch implements the ultraclient.Discoverer interface, the endpoints are
+// sent when they change and an empty set is sent when the file can not be
+// read
+func (f *File) Watch(ctx context.Context) <-chan []ultraclient.Endpoint {
+	var modified time.Time
+	var last []ultraclient.Endpoint
+
+	interval := f.interval()
+
+	return poll(ctx, func(ctx context.Context) ([]ultraclient.Endpoint, time.Duration, error) {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return nil, interval, err
+		}
+
+		// the file is only parsed when it has been written
+		if last != nil && info.ModTime().Equal(modified) {
+			return last, interval, nil
+		}
+
+		endpoints, err := f.read()
+		if err != nil {
+			last = nil
+			return nil, interval, err
+		}
+
+		modified = info.ModTime()
+		last = endpoints
+
+		return endpoints, interval, nil
+	})
+}
+
+// interval returns the time to wait before the next check
+func (f *File) interval() time.Duration {
+	if f.Interval <= 0 {
+		return DefaultFileInterval
+	}
+
+	return f.Interval
+}
+
+func (f *File) read() ([]ultraclient.Endpoint, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fileEndpoint
+
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".json":
+		err = json.Unmarshal(data, &entries)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	default:
+		entries, err = parseText(data)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read endpoints from %v: %v", f.Path, err)
+	}
+
+	endpoints := make([]ultraclient.Endpoint, 0, len(entries))
+	for _, entry := range entries {
+		endpoint, err := entry.endpoint()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read endpoints from %v: %v", f.Path, err)
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sortEndpoints(endpoints)
+	return endpoints, nil
+}
+
+// fileEndpoint is an endpoint in a file, it is written as an address or as an
+// object
+type fileEndpoint struct {
+	Address  string            `json:"address" yaml:"address"`
+	Weight   int               `json:"weight" yaml:"weight"`
+	Zone     string            `json:"zone" yaml:"zone"`
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+// UnmarshalJSON decodes an endpoint written as an address or as an object
+func (f *fileEndpoint) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &f.Address)
+	}
+
+	type plain fileEndpoint
+	return json.Unmarshal(data, (*plain)(f))
+}
+
+// UnmarshalYAML decodes an endpoint written as an address or as an object
+func (f *fileEndpoint) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&f.Address)
+	}
+
+	type plain fileEndpoint
+	return node.Decode((*plain)(f))
+}
+
+// endpoint returns the endpoint with its weight, zone and metadata, the
+// weight is 1 when not given
+func (f fileEndpoint) endpoint() (ultraclient.Endpoint, error) {
+	endpoint := url.URL{Host: f.Address}
+	if strings.Contains(f.Address, "://") {
+		u, err := url.Parse(f.Address)
+		if err != nil {
+			return ultraclient.Endpoint{}, fmt.Errorf("invalid endpoint %v: %v", f.Address, err)
+		}
+
+		endpoint = *u
+	}
+
+	if endpoint.Host == "" {
+		return ultraclient.Endpoint{}, fmt.Errorf("invalid endpoint %q", f.Address)
+	}
+
+	metadata := ultraclient.EndpointMetadata{Weight: 1, Zone: f.Zone}
+	if f.Weight > 0 {
+		metadata.Weight = f.Weight
+	}
+
+	if len(f.Metadata) > 0 {
+		metadata.Labels = f.Metadata
+	}
+
+	return ultraclient.Endpoint{URL: endpoint, Metadata: metadata}, nil
+}
+
+// parseText reads an endpoint from each line, the address is followed by
+// optional key=value metadata
+func parseText(data []byte) ([]fileEndpoint, error) {
+	var entries []fileEndpoint
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		entry := fileEndpoint{Address: fields[0], Metadata: map[string]string{}}
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid metadata %q for endpoint %v, must be key=value", field, fields[0])
+			}
+
+			switch parts[0] {
+			case "weight":
+				weight, err := strconv.Atoi(parts[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid weight %q for endpoint %v", parts[1], fields[0])
+				}
+
+				entry.Weight = weight
+			case "zone":
+				entry.Zone = parts[1]
+			default:
+				entry.Metadata[parts[0]] = parts[1]
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,104 @@
+package ultradiscovery
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeEndpointsFile(t *testing.T, path, content string) {
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+
+	// the modification time must change for the file to be read again
+	modified := time.Now().Add(time.Duration(len(content)) * time.Second)
+	assert.Nil(t, os.Chtimes(path, modified, modified))
+}
+
+func setupFile(t *testing.T, name, content string) (string, <-chan []ultraclient.Endpoint) {
+	path := filepath.Join(t.TempDir(), name)
+	writeEndpointsFile(t, path, content)
+
+	f := NewFile(path)
+	f.Interval = 1 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return path, f.Watch(ctx)
+}
+
+func TestFileReadsYAMLEndpoints(t *testing.T) {
+	_, updates := setupFile(t, "backends.yaml", `
+- server1:8080
+- address: http://server2:8080
+  weight: 10
+  zone: eu-west-1a
+  metadata:
+    version: v2
+`)
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Host: "server1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+		{URL: url.URL{Scheme: "http", Host: "server2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 10, Zone: "eu-west-1a", Labels: map[string]string{"version": "v2"}}},
+	}, <-updates)
+}
+
+func TestFileReadsJSONEndpoints(t *testing.T) {
+	_, updates := setupFile(t, "backends.json", `["server1:8080", {"address": "server2:8080", "zone": "eu-west-1b"}]`)
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Host: "server1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+		{URL: url.URL{Host: "server2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1, Zone: "eu-west-1b"}},
+	}, <-updates)
+}
+
+func TestFileReadsPlainTextEndpoints(t *testing.T) {
+	_, updates := setupFile(t, "backends", `
+# backends for myservice
+server1:8080
+http://server2:8080 weight=10 zone=eu-west-1a version=v2
+`)
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Host: "server1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+		{URL: url.URL{Scheme: "http", Host: "server2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 10, Zone: "eu-west-1a", Labels: map[string]string{"version": "v2"}}},
+	}, <-updates)
+}
+
+func TestFileSendsEndpointsWhenFileChanges(t *testing.T) {
+	path, updates := setupFile(t, "backends", "server1:8080")
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "server1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+
+	writeEndpointsFile(t, path, "server2:8080\nserver3:8080")
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "server2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}, {URL: url.URL{Host: "server3:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+}
+
+func TestFileSendsEmptyEndpointsWhenFileIsInvalid(t *testing.T) {
+	path, updates := setupFile(t, "backends", "server1:8080")
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "server1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+
+	writeEndpointsFile(t, path, "server2:8080 notmetadata")
+
+	assert.Empty(t, <-updates)
+}
+
+func TestFileSendsEmptyEndpointsWhenFileIsMissing(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "missing.yaml"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Empty(t, <-f.Watch(ctx))
+}
+
+func TestFileDefaultsInterval(t *testing.T) {
+	assert.Equal(t, DefaultFileInterval, (&File{}).interval())
+	assert.Equal(t, 5*time.Second, (&File{Interval: 5 * time.Second}).interval())
+}
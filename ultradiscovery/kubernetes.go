@@ -0,0 +1,426 @@
+package ultradiscovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+)
+
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// errWatchExpired is returned when the resource version of a watch is too old
+// and the EndpointSlices must be listed again
+var errWatchExpired = errors.New("watch expired")
+
+// Kubernetes is a Discoverer which reads the EndpointSlices of a service from
+// the Kubernetes API, the slices are listed and then watched for changes.
+// Endpoints which are ready are used, when no endpoint is ready endpoints
+// which are still serving while terminating are used instead.
+type Kubernetes struct {
+	// Address is the address of the Kubernetes API server
+	Address string
+
+	// Namespace and Service identify the service
+	Namespace string
+	Service   string
+
+	// PortName is the name of the port in the EndpointSlices, it can be empty
+	// when the service has a single port
+	PortName string
+
+	// Zone is the zone of the client, when every endpoint has topology hints
+	// only the endpoints hinted for the zone are used
+	Zone string
+
+	// Scheme is the scheme given to each endpoint
+	Scheme string
+
+	// Token is the bearer token sent with each request
+	Token string
+
+	// TokenFile is a file holding the bearer token, it is read for each
+	// request so a rotated token is used and replaces Token when set
+	TokenFile string
+
+	// RetryInterval is the time to wait before listing again after a failure
+	// and before watching again after a watch which ended without any events,
+	// an interval which is not greater than zero is replaced with
+	// DefaultKubernetesRetryInterval
+	RetryInterval time.Duration
+
+	// Client is the http client used to query the API server
+	Client *http.Client
+}
+
+// DefaultKubernetesRetryInterval is the RetryInterval a Kubernetes uses when
+// it is not given one
+const DefaultKubernetesRetryInterval = 1 * time.Second
+
+// NewKubernetes creates a Discoverer for the service
+func NewKubernetes(address, namespace, service string) *Kubernetes {
+	return &Kubernetes{
+		Address:       address,
+		Namespace:     namespace,
+		Service:       service,
+		RetryInterval: DefaultKubernetesRetryInterval,
+		Client:        http.DefaultClient,
+	}
+}
+
+// NewKubernetesInCluster creates a Discoverer for the service in the
+// namespace of the pod, the API server and credentials are read from the
+// environment and service account of the pod, the token is read again for
+// each request as it is rotated by the kubelet
+// discoverer, err := ultradiscovery.NewKubernetesInCluster("myservice")
+func NewKubernetesInCluster(service string) (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("unable to find the kubernetes API, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	tokenFile := serviceAccountPath + "/token"
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, err
+	}
+
+	namespace, err := os.ReadFile(serviceAccountPath + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := os.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+
+	k := NewKubernetes("https://"+net.JoinHostPort(host, port), strings.TrimSpace(string(namespace)), service)
+	k.TokenFile = tokenFile
+	k.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	return k, nil
+}
+
+// endpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice used for
+// discovery
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready       *bool `json:"ready"`
+			Serving     *bool `json:"serving"`
+			Terminating *bool `json:"terminating"`
+		} `json:"conditions"`
+		Zone  string `json:"zone"`
+		Hints *struct {
+			ForZones []struct {
+				Name string `json:"name"`
+			} `json:"forZones"`
+		} `json:"hints"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int    `json:"port"`
+	} `json:"ports"`
+}
+
+type endpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []endpointSlice `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Watch implements the ultraclient.Discoverer interface, the endpoints are
+// sent when they change and an empty set is sent when the API server can not
+// be queried
+func (k *Kubernetes) Watch(ctx context.Context) <-chan []ultraclient.Endpoint {
+	u := newUpdater()
+
+	go func() {
+		defer close(u.updates)
+
+		for {
+			err := k.listAndWatch(ctx, u)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != errWatchExpired {
+				if !u.send(ctx, nil, err) || !sleep(ctx, k.retryInterval()) {
+					return
+				}
+			}
+		}
+	}()
+
+	return u.updates
+}
+
+// listAndWatch lists the EndpointSlices and then watches them until the
+// watch fails
+func (k *Kubernetes) listAndWatch(ctx context.Context, u *updater) error {
+	list := endpointSliceList{}
+	if err := k.get(ctx, url.Values{}, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&list)
+	}); err != nil {
+		return err
+	}
+
+	slices := map[string]endpointSlice{}
+	for _, slice := range list.Items {
+		slices[slice.Metadata.Name] = slice
+	}
+
+	if !u.send(ctx, k.endpoints(slices), nil) {
+		return ctx.Err()
+	}
+
+	resourceVersion := list.Metadata.ResourceVersion
+	for {
+		query := url.Values{}
+		query.Set("watch", "true")
+		query.Set("allowWatchBookmarks", "true")
+		query.Set("resourceVersion", resourceVersion)
+
+		events := 0
+		err := k.get(ctx, query, func(resp *http.Response) error {
+			decoder := json.NewDecoder(resp.Body)
+			for {
+				event := watchEvent{}
+				if err := decoder.Decode(&event); err != nil {
+					return err
+				}
+				events++
+
+				var err error
+				resourceVersion, err = k.apply(slices, event, resourceVersion)
+				if err != nil {
+					return err
+				}
+
+				if !u.send(ctx, k.endpoints(slices), nil) {
+					return ctx.Err()
+				}
+			}
+		})
+
+		// the API server closes watches after a timeout, the watch is started
+		// again from the last resource version
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// a watch which ends at once would otherwise be started again in a
+		// tight loop
+		if events == 0 && !sleep(ctx, k.retryInterval()) {
+			return ctx.Err()
+		}
+	}
+}
+
+// retryInterval returns the time to wait before listing again after a failure
+// or watching again after an empty watch
+func (k *Kubernetes) retryInterval() time.Duration {
+	if k.RetryInterval <= 0 {
+		return DefaultKubernetesRetryInterval
+	}
+
+	return k.RetryInterval
+}
+
+// token returns the bearer token, read from TokenFile when it is set
+func (k *Kubernetes) token() (string, error) {
+	if k.TokenFile == "" {
+		return k.Token, nil
+	}
+
+	token, err := os.ReadFile(k.TokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+// apply updates the slices from the watch event and returns the resource
+// version to watch from
+func (k *Kubernetes) apply(slices map[string]endpointSlice, event watchEvent, resourceVersion string) (string, error) {
+	if event.Type == "ERROR" {
+		status := struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}{}
+		json.Unmarshal(event.Object, &status)
+
+		if status.Code == http.StatusGone {
+			return "", errWatchExpired
+		}
+
+		return "", fmt.Errorf("watch failed: %v", status.Message)
+	}
+
+	slice := endpointSlice{}
+	if err := json.Unmarshal(event.Object, &slice); err != nil {
+		return "", err
+	}
+
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		slices[slice.Metadata.Name] = slice
+	case "DELETED":
+		delete(slices, slice.Metadata.Name)
+	}
+
+	return slice.Metadata.ResourceVersion, nil
+}
+
+// get requests the EndpointSlices of the service and passes the response to
+// read
+func (k *Kubernetes) get(ctx context.Context, query url.Values, read func(resp *http.Response) error) error {
+	query.Set("labelSelector", "kubernetes.io/service-name="+k.Service)
+
+	u := fmt.Sprintf("%v/apis/discovery.k8s.io/v1/namespaces/%v/endpointslices?%v",
+		strings.TrimSuffix(k.Address, "/"),
+		url.PathEscape(k.Namespace),
+		query.Encode(),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	token, err := k.token()
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return read(resp)
+	case http.StatusGone:
+		return errWatchExpired
+	default:
+		return fmt.Errorf("kubernetes API returned status: %v", resp.Status)
+	}
+}
+
+// endpoints returns the ready endpoints of the slices, or the serving
+// endpoints when none are ready, filtered by the topology hints for the zone
+func (k *Kubernetes) endpoints(slices map[string]endpointSlice) []ultraclient.Endpoint {
+	var ready, serving, hinted []ultraclient.Endpoint
+	allHinted := true
+
+	for _, slice := range slices {
+		port, ok := k.port(slice)
+		if !ok {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			if len(endpoint.Addresses) == 0 {
+				continue
+			}
+
+			u := ultraclient.Endpoint{
+				URL: url.URL{
+					Scheme: k.Scheme,
+					Host:   net.JoinHostPort(endpoint.Addresses[0], strconv.Itoa(port)),
+				},
+				Metadata: ultraclient.EndpointMetadata{Weight: 1, Zone: endpoint.Zone},
+			}
+
+			conditions := endpoint.Conditions
+			terminating := conditions.Terminating != nil && *conditions.Terminating
+
+			// a ready condition which is not set means the endpoint is ready
+			switch {
+			case !terminating && (conditions.Ready == nil || *conditions.Ready):
+				ready = append(ready, u)
+			case terminating && conditions.Serving != nil && *conditions.Serving:
+				serving = append(serving, u)
+				continue
+			default:
+				continue
+			}
+
+			if endpoint.Hints == nil {
+				allHinted = false
+				continue
+			}
+
+			for _, zone := range endpoint.Hints.ForZones {
+				if zone.Name == k.Zone {
+					hinted = append(hinted, u)
+					break
+				}
+			}
+		}
+	}
+
+	endpoints := ready
+	switch {
+	case len(ready) == 0:
+		endpoints = serving
+	case k.Zone != "" && allHinted && len(hinted) > 0:
+		endpoints = hinted
+	}
+
+	if endpoints == nil {
+		endpoints = []ultraclient.Endpoint{}
+	}
+
+	sortEndpoints(endpoints)
+	return endpoints
+}
+
+// port returns the port of the slice with the PortName
+func (k *Kubernetes) port(slice endpointSlice) (int, bool) {
+	for _, port := range slice.Ports {
+		name := ""
+		if port.Name != nil {
+			name = *port.Name
+		}
+
+		if name == k.PortName && port.Port != nil {
+			return *port.Port, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,286 @@
+package ultradiscovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// kubernetesServer is a fake Kubernetes API server, lists return the slices
+// and watches stream the events sent to the server
+type kubernetesServer struct {
+	sync.Mutex
+	slices   string
+	events   chan string
+	lists    int
+	requests []*http.Request
+}
+
+func (k *kubernetesServer) listCount() int {
+	k.Lock()
+	defer k.Unlock()
+
+	return k.lists
+}
+
+func (k *kubernetesServer) firstRequest() *http.Request {
+	k.Lock()
+	defer k.Unlock()
+
+	return k.requests[0]
+}
+
+func (k *kubernetesServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	k.Lock()
+	k.requests = append(k.requests, r)
+	k.Unlock()
+
+	if r.URL.Query().Get("watch") != "true" {
+		k.Lock()
+		k.lists++
+		fmt.Fprintf(rw, `{"metadata": {"resourceVersion": "1"}, "items": [%v]}`, k.slices)
+		k.Unlock()
+
+		return
+	}
+
+	rw.(http.Flusher).Flush()
+	for {
+		select {
+		case event := <-k.events:
+			fmt.Fprintln(rw, event)
+			rw.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func setupKubernetes(t *testing.T, slices string) (*kubernetesServer, <-chan []ultraclient.Endpoint) {
+	server := &kubernetesServer{slices: slices, events: make(chan string)}
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	k := NewKubernetes(httpServer.URL, "default", "myservice")
+	k.PortName = "http"
+	k.Token = "secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return server, k.Watch(ctx)
+}
+
+func slice(name, endpoints string) string {
+	return fmt.Sprintf(`{
+  "metadata": {"name": %q, "resourceVersion": "2"},
+  "ports": [{"name": "metrics", "port": 9090}, {"name": "http", "port": 8080}],
+  "endpoints": [%v]
+}`, name, endpoints)
+}
+
+func slices(t *testing.T, items ...string) map[string]endpointSlice {
+	result := map[string]endpointSlice{}
+	for _, item := range items {
+		s := endpointSlice{}
+		assert.Nil(t, json.Unmarshal([]byte(item), &s))
+		result[s.Metadata.Name] = s
+	}
+
+	return result
+}
+
+const (
+	readyEndpoint       = `{"addresses": ["10.0.0.1"], "conditions": {"ready": true}, "zone": "eu-west-1a"}`
+	notReadyEndpoint    = `{"addresses": ["10.0.0.2"], "conditions": {"ready": false}}`
+	terminatingEndpoint = `{"addresses": ["10.0.0.3"], "conditions": {"ready": false, "serving": true, "terminating": true}}`
+)
+
+func TestKubernetesDiscoversReadyEndpoints(t *testing.T) {
+	server, updates := setupKubernetes(t,
+		slice("myservice-abc", readyEndpoint+","+notReadyEndpoint+","+terminatingEndpoint))
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1, Zone: "eu-west-1a"}}}, <-updates)
+
+	req := server.firstRequest()
+	assert.Equal(t, "/apis/discovery.k8s.io/v1/namespaces/default/endpointslices", req.URL.Path)
+	assert.Equal(t, "kubernetes.io/service-name=myservice", req.URL.Query().Get("labelSelector"))
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+}
+
+func TestKubernetesWatchesForChanges(t *testing.T) {
+	server, updates := setupKubernetes(t, slice("myservice-abc", readyEndpoint))
+	<-updates
+
+	server.events <- fmt.Sprintf(`{"type": "ADDED", "object": %v}`,
+		slice("myservice-def", `{"addresses": ["10.0.0.4"]}`))
+
+	assert.Equal(t, []ultraclient.Endpoint{
+		{URL: url.URL{Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1, Zone: "eu-west-1a"}},
+		{URL: url.URL{Host: "10.0.0.4:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}},
+	}, <-updates)
+
+	server.events <- fmt.Sprintf(`{"type": "DELETED", "object": %v}`, slice("myservice-abc", ""))
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.4:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+}
+
+func TestKubernetesListsAgainWhenWatchExpires(t *testing.T) {
+	server, updates := setupKubernetes(t, slice("myservice-abc", readyEndpoint))
+	<-updates
+
+	server.Lock()
+	server.slices = slice("myservice-abc", `{"addresses": ["10.0.0.5"]}`)
+	server.Unlock()
+
+	server.events <- `{"type": "ERROR", "object": {"kind": "Status", "code": 410, "message": "too old resource version"}}`
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.5:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, <-updates)
+	assert.Equal(t, 2, server.listCount())
+}
+
+func TestKubernetesSendsEmptyEndpointsWhenListFails(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer httpServer.Close()
+
+	k := NewKubernetes(httpServer.URL, "default", "myservice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Empty(t, <-k.Watch(ctx))
+}
+
+func TestKubernetesUsesServingEndpointsWhenNoneAreReady(t *testing.T) {
+	k := NewKubernetes("", "default", "myservice")
+	k.PortName = "http"
+
+	endpoints := k.endpoints(slices(t, slice("myservice-abc", notReadyEndpoint+","+terminatingEndpoint)))
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.3:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, endpoints)
+}
+
+func TestKubernetesUsesTopologyHintsForZone(t *testing.T) {
+	k := NewKubernetes("", "default", "myservice")
+	k.PortName = "http"
+	k.Zone = "eu-west-1a"
+
+	endpoints := k.endpoints(slices(t, slice("myservice-abc", `
+{"addresses": ["10.0.0.1"], "zone": "eu-west-1a", "hints": {"forZones": [{"name": "eu-west-1a"}]}},
+{"addresses": ["10.0.0.2"], "zone": "eu-west-1b", "hints": {"forZones": [{"name": "eu-west-1b"}]}}`)))
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1, Zone: "eu-west-1a"}}}, endpoints)
+}
+
+func TestKubernetesIgnoresTopologyHintsUnlessEveryEndpointHasThem(t *testing.T) {
+	k := NewKubernetes("", "default", "myservice")
+	k.PortName = "http"
+	k.Zone = "eu-west-1a"
+
+	endpoints := k.endpoints(slices(t, slice("myservice-abc", `
+{"addresses": ["10.0.0.1"], "hints": {"forZones": [{"name": "eu-west-1a"}]}},
+{"addresses": ["10.0.0.2"]}`)))
+
+	assert.Equal(t, []ultraclient.Endpoint{{URL: url.URL{Host: "10.0.0.1:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}, {URL: url.URL{Host: "10.0.0.2:8080"}, Metadata: ultraclient.EndpointMetadata{Weight: 1}}}, endpoints)
+}
+
+// closingServer lists the slices and closes every watch at once, it records
+// the token sent with each request
+type closingServer struct {
+	sync.Mutex
+	watches int
+	tokens  []string
+}
+
+func (c *closingServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.tokens = append(c.tokens, r.Header.Get("Authorization"))
+
+	if r.URL.Query().Get("watch") == "true" {
+		c.watches++
+		return
+	}
+
+	fmt.Fprintf(rw, `{"metadata": {"resourceVersion": "1"}, "items": [%v]}`, slice("myservice-abc", readyEndpoint))
+}
+
+func (c *closingServer) requests() (int, []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.watches, append([]string(nil), c.tokens...)
+}
+
+func setupClosingServer(t *testing.T) (*closingServer, *Kubernetes) {
+	server := &closingServer{}
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	k := NewKubernetes(httpServer.URL, "default", "myservice")
+	k.PortName = "http"
+
+	return server, k
+}
+
+func TestKubernetesWaitsBeforeWatchingAgainAfterEmptyWatch(t *testing.T) {
+	server, k := setupClosingServer(t)
+	k.RetryInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	<-k.Watch(ctx)
+	time.Sleep(120 * time.Millisecond)
+
+	watches, _ := server.requests()
+	assert.True(t, watches >= 1 && watches <= 4, "expected a few watches, got %v", watches)
+}
+
+func TestKubernetesReadsTokenFileForEachRequest(t *testing.T) {
+	server, k := setupClosingServer(t)
+	k.RetryInterval = 5 * time.Millisecond
+	k.TokenFile = filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(k.TokenFile, []byte("first\n"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	<-k.Watch(ctx)
+	assert.Nil(t, os.WriteFile(k.TokenFile, []byte("second\n"), 0644))
+
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		_, tokens := server.requests()
+		if tokens[len(tokens)-1] == "Bearer second" {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	_, tokens := server.requests()
+	assert.Equal(t, "Bearer first", tokens[0])
+	assert.Equal(t, "Bearer second", tokens[len(tokens)-1])
+}
+
+func TestKubernetesDefaultsRetryInterval(t *testing.T) {
+	assert.Equal(t, DefaultKubernetesRetryInterval, (&Kubernetes{}).retryInterval())
+	assert.Equal(t, 5*time.Second, (&Kubernetes{RetryInterval: 5 * time.Second}).retryInterval())
+}
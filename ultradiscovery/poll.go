@@ -0,0 +1,85 @@
+package ultradiscovery
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+)
+
+// lookupFunc finds the endpoints and returns the time to wait before the next
+// lookup
+type lookupFunc func(ctx context.Context) ([]ultraclient.Endpoint, time.Duration, error)
+
+// poll calls lookup until the context is done, the endpoints are sent when
+// they change and an empty set is sent each time the lookup fails
+func poll(ctx context.Context, lookup lookupFunc) <-chan []ultraclient.Endpoint {
+	u := newUpdater()
+
+	go func() {
+		defer close(u.updates)
+
+		for {
+			endpoints, wait, err := lookup(ctx)
+			if !u.send(ctx, endpoints, err) {
+				return
+			}
+
+			if !sleep(ctx, wait) {
+				return
+			}
+		}
+	}()
+
+	return u.updates
+}
+
+// updater sends endpoints to a Discoverer channel
+type updater struct {
+	updates chan []ultraclient.Endpoint
+	last    []ultraclient.Endpoint
+}
+
+func newUpdater() *updater {
+	return &updater{updates: make(chan []ultraclient.Endpoint)}
+}
+
+// send sends the endpoints when they have changed and an empty set when err
+// is not nil, false is returned when the context is done
+func (u *updater) send(ctx context.Context, endpoints []ultraclient.Endpoint, err error) bool {
+	if err != nil {
+		endpoints = nil
+	} else if reflect.DeepEqual(endpoints, u.last) {
+		return true
+	}
+
+	select {
+	case u.updates <- endpoints:
+		u.last = endpoints
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep waits for the duration, false is returned when the context is done
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// sortEndpoints sorts the endpoints by host so that sets can be compared
+func sortEndpoints(endpoints []ultraclient.Endpoint) {
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].URL.Host < endpoints[j].URL.Host
+	})
+}
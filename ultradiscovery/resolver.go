@@ -0,0 +1,266 @@
+package ultradiscovery
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Record is a DNS record found by a Resolver
+type Record struct {
+	// Target is the IP address of an A or AAAA record or the target host of
+	// an SRV record
+	Target string
+
+	// Port, Priority and Weight are set for SRV records
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+
+	// TTL is the length of time the record can be cached
+	TTL time.Duration
+}
+
+// Resolver looks up DNS records, the standard library resolver does not
+// return TTLs so the DNS Discoverer uses its own.  Tests can replace it to
+// work without a network.
+type Resolver interface {
+	// LookupHost returns the A and AAAA records for the name
+	LookupHost(ctx context.Context, name string) ([]Record, error)
+
+	// LookupSRV returns the SRV records for the name
+	LookupSRV(ctx context.Context, name string) ([]Record, error)
+}
+
+// DNSResolver is a Resolver which queries DNS servers directly, names are
+// looked up as fully qualified names.
+type DNSResolver struct {
+	// Servers are the addresses of the DNS servers, each server is tried in
+	// turn until one answers
+	Servers []string
+
+	// Timeout is the length of time to wait for each server to answer
+	Timeout time.Duration
+}
+
+// NewDNSResolver creates a resolver for the given servers, when no servers
+// are given the nameservers in /etc/resolv.conf are used
+// resolver := ultradiscovery.NewDNSResolver("127.0.0.1:8600")
+func NewDNSResolver(servers ...string) *DNSResolver {
+	if len(servers) == 0 {
+		servers = systemServers("/etc/resolv.conf")
+	}
+
+	return &DNSResolver{Servers: servers, Timeout: 2 * time.Second}
+}
+
+// LookupHost implements the Resolver interface, an error is returned only
+// when both the A and AAAA lookups fail
+func (r *DNSResolver) LookupHost(ctx context.Context, name string) ([]Record, error) {
+	a, aErr := r.lookup(ctx, name, dnsmessage.TypeA)
+	aaaa, aaaaErr := r.lookup(ctx, name, dnsmessage.TypeAAAA)
+
+	if aErr != nil && aaaaErr != nil {
+		return nil, aErr
+	}
+
+	return append(a, aaaa...), nil
+}
+
+// LookupSRV implements the Resolver interface
+func (r *DNSResolver) LookupSRV(ctx context.Context, name string) ([]Record, error) {
+	return r.lookup(ctx, name, dnsmessage.TypeSRV)
+}
+
+func (r *DNSResolver) lookup(ctx context.Context, name string, qtype dnsmessage.Type) ([]Record, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := queryID()
+	if err != nil {
+		return nil, err
+	}
+
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	err = errors.New("no DNS servers configured")
+	for _, server := range r.Servers {
+		var response *dnsmessage.Message
+		response, err = r.exchange(ctx, server, packed, query.ID)
+		if err != nil {
+			continue
+		}
+
+		if response.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("lookup %v %v: %v", qtype, name, response.RCode)
+		}
+
+		return records(response.Answers), nil
+	}
+
+	return nil, fmt.Errorf("lookup %v %v: %v", qtype, name, err)
+}
+
+// exchange sends the query to the server over UDP, the query is sent again
+// over TCP when the response is truncated
+func (r *DNSResolver) exchange(ctx context.Context, server string, query []byte, id uint16) (*dnsmessage.Message, error) {
+	response, err := r.exchangeNetwork(ctx, "udp", server, query)
+	if err == nil && response.Truncated {
+		response, err = r.exchangeNetwork(ctx, "tcp", server, query)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Response || response.ID != id {
+		return nil, errors.New("invalid response from " + server)
+	}
+
+	return response, nil
+}
+
+func (r *DNSResolver) exchangeNetwork(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		buf, err = exchangeTCP(conn, query)
+	} else {
+		buf, err = exchangeUDP(conn, query)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dnsmessage.Message{}
+	return response, response.Unpack(buf)
+}
+
+func exchangeUDP(conn net.Conn, query []byte) ([]byte, error) {
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+
+	return buf[:n], err
+}
+
+// exchangeTCP sends the query prefixed with its length as DNS over TCP
+// requires
+func exchangeTCP(conn net.Conn, query []byte) ([]byte, error) {
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	_, err := io.ReadFull(conn, buf)
+
+	return buf, err
+}
+
+func records(answers []dnsmessage.Resource) []Record {
+	var records []Record
+
+	for _, answer := range answers {
+		ttl := time.Duration(answer.Header.TTL) * time.Second
+
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			records = append(records, Record{Target: net.IP(body.A[:]).String(), TTL: ttl})
+		case *dnsmessage.AAAAResource:
+			records = append(records, Record{Target: net.IP(body.AAAA[:]).String(), TTL: ttl})
+		case *dnsmessage.SRVResource:
+			records = append(records, Record{
+				Target:   body.Target.String(),
+				Port:     body.Port,
+				Priority: body.Priority,
+				Weight:   body.Weight,
+				TTL:      ttl,
+			})
+		}
+	}
+
+	return records
+}
+
+// systemServers reads the nameservers from a resolv.conf file
+func systemServers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return []string{"127.0.0.1:53"}
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+
+	if len(servers) == 0 {
+		return []string{"127.0.0.1:53"}
+	}
+
+	return servers
+}
+
+// queryID returns a random ID for a query so responses are hard to spoof
+func queryID() (uint16, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(id[:]), nil
+}
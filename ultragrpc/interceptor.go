@@ -0,0 +1,281 @@
+// Package ultragrpc provides gRPC client interceptors which run calls through
+// an ultraclient.Client, giving gRPC clients loadbalancing, circuit breaking,
+// retries and stats.
+package ultragrpc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// pushbackKey is the trailer a server uses to tell the client how long to
+// wait before retrying a call
+const pushbackKey = "grpc-retry-pushback-ms"
+
+// Interceptor runs gRPC calls through an ultraclient.Client, each attempt is
+// sent to a connection for the endpoint chosen by the loadbalancer rather than
+// the connection the call was made on.
+// Unavailable, DeadlineExceeded and ResourceExhausted are retried and count
+// towards opening the circuit, Internal, Unknown and DataLoss count towards
+// opening the circuit but are not retried, any other code is returned to the
+// caller without retrying or affecting the circuit.
+// interceptor := ultragrpc.NewInterceptor(client, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// conn, _ := grpc.NewClient(
+//   "passthrough:///myservice",
+//   grpc.WithTransportCredentials(insecure.NewCredentials()),
+//   grpc.WithUnaryInterceptor(interceptor.UnaryClientInterceptor()),
+//   grpc.WithStreamInterceptor(interceptor.StreamClientInterceptor()),
+// )
+type Interceptor struct {
+	client      ultraclient.Client
+	dialOptions []grpc.DialOption
+
+	sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewInterceptor creates a new Interceptor, the dial options are used when
+// creating the connection to each endpoint
+func NewInterceptor(client ultraclient.Client, opts ...grpc.DialOption) *Interceptor {
+	return &Interceptor{
+		client:      client,
+		dialOptions: opts,
+		conns:       make(map[string]*grpc.ClientConn),
+	}
+}
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor which runs
+// each unary call through the client
+func (i *Interceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption) error {
+
+		result := ultraclient.NewFirstResult[interface{}](nil)
+
+		err := i.client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+			conn, err := i.conn(endpoint)
+			if err != nil {
+				return ultraclient.NonRetryable(err)
+			}
+
+			attemptReply := newReply(reply)
+
+			var trailer metadata.MD
+			err = invoker(ctx, method, req, attemptReply, conn, append(opts, grpc.Trailer(&trailer))...)
+			if err != nil {
+				return classifyError(ctx, err, trailer)
+			}
+
+			result.Set(attemptReply)
+			return nil
+		})
+
+		if attemptReply := result.Finish(err); err == nil {
+			copyReply(reply, attemptReply)
+		}
+
+		return statusError(err)
+	}
+}
+
+// StreamClientInterceptor returns a grpc.StreamClientInterceptor which runs
+// the creation of each stream through the client, messages sent and received
+// on an established stream are not retried.
+func (i *Interceptor) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+		result := ultraclient.NewFirstResult(func(stream *clientStream) { stream.cancel() })
+
+		err := i.client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+			conn, err := i.conn(endpoint)
+			if err != nil {
+				return ultraclient.NonRetryable(err)
+			}
+
+			sctx, cancel := context.WithCancel(ctx)
+			s, err := streamer(sctx, desc, conn, method, opts...)
+			if err != nil {
+				cancel()
+				return classifyError(ctx, err, nil)
+			}
+
+			result.Set(&clientStream{ClientStream: s, cancel: cancel})
+			return nil
+		})
+
+		stream := result.Finish(err)
+		if err != nil {
+			return nil, statusError(err)
+		}
+
+		return stream, nil
+	}
+}
+
+// Close closes the connections to all the endpoints
+func (i *Interceptor) Close() error {
+	i.Lock()
+	defer i.Unlock()
+
+	var err error
+	for host, conn := range i.conns {
+		if cerr := conn.Close(); cerr != nil {
+			err = cerr
+		}
+
+		delete(i.conns, host)
+	}
+
+	return err
+}
+
+func (i *Interceptor) conn(endpoint url.URL) (*grpc.ClientConn, error) {
+	endpoints := i.client.Endpoints()
+
+	i.Lock()
+	defer i.Unlock()
+
+	conn, ok := i.conns[endpoint.Host]
+	if !ok || len(i.conns) > len(endpoints) {
+		i.prune(endpoints)
+	}
+
+	if ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.NewClient("passthrough:///"+endpoint.Host, i.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	i.conns[endpoint.Host] = conn
+	return conn, nil
+}
+
+// prune closes the connections to endpoints which have been removed from the
+// client by discovery or Reload, the caller must hold the lock.  The set of
+// endpoints only needs checking when a new endpoint is used or there are more
+// connections than endpoints.
+func (i *Interceptor) prune(endpoints []url.URL) {
+	current := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		current[endpoint.Host] = true
+	}
+
+	for host, conn := range i.conns {
+		if !current[host] {
+			conn.Close()
+			delete(i.conns, host)
+		}
+	}
+}
+
+// classifyError decides whether the error is retried and whether it counts
+// towards opening the circuit, an error caused by the caller's context being
+// done is not a failure of the endpoint
+func classifyError(ctx context.Context, err error, trailer metadata.MD) error {
+	if ctx.Err() != nil {
+		return ultraclient.BadRequest(err)
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return err
+	case codes.ResourceExhausted:
+		if delay, ok := parsePushback(trailer); ok {
+			return ultraclient.RetryAfter(err, delay)
+		}
+
+		return err
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return ultraclient.NonRetryable(err)
+	default:
+		return ultraclient.BadRequest(err)
+	}
+}
+
+func parsePushback(trailer metadata.MD) (time.Duration, bool) {
+	values := trailer.Get(pushbackKey)
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	ms, err := strconv.Atoi(values[0])
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// statusError returns the gRPC status returned by the server, errors raised
+// by the client such as an open circuit are returned as Unavailable
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.(error)
+	}
+
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// newReply creates the message an attempt decodes into, each attempt decodes
+// into its own message so the callers reply is only written with the reply
+// of the attempt which is returned
+func newReply(reply interface{}) interface{} {
+	if m, ok := reply.(proto.Message); ok {
+		return m.ProtoReflect().New().Interface()
+	}
+
+	return reply
+}
+
+// copyReply copies the reply of the attempt into the callers reply
+func copyReply(reply, attemptReply interface{}) {
+	if m, ok := reply.(proto.Message); ok {
+		proto.Reset(m)
+		proto.Merge(m, attemptReply.(proto.Message))
+	}
+}
+
+// clientStream releases the resources for the stream once it has finished
+type clientStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+func (c *clientStream) RecvMsg(m interface{}) error {
+	err := c.ClientStream.RecvMsg(m)
+	if err != nil {
+		c.cancel()
+	}
+
+	return err
+}
@@ -0,0 +1,233 @@
+package ultragrpc
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/nicholasjackson/ultraclient/internal/tracetest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer returns the configured errors in order before succeeding
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+
+	sync.Mutex
+	errors  []error
+	trailer metadata.MD
+	calls   int
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.calls++
+	if h.calls <= len(h.errors) {
+		grpc.SetTrailer(ctx, h.trailer)
+		return nil, h.errors[h.calls-1]
+	}
+
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	return health.NewServer().Watch(req, stream)
+}
+
+var server *healthServer
+var uclient ultraclient.Client
+var interceptor *Interceptor
+
+func setupInterceptor(t *testing.T, errors ...error) healthpb.HealthClient {
+	hystrix.Flush()
+
+	server = &healthServer{errors: errors}
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+
+	uclient = ultraclient.NewClient(
+		ultraclient.Config{
+			Timeout:                1 * time.Second,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  100,
+			DefaultVolumeThreshold: 100,
+			Retries:                2,
+			RetryDelay:             1 * time.Millisecond,
+			Endpoints:              []url.URL{url.URL{Host: "server1:9090"}},
+		},
+		&ultraclient.RoundRobinStrategy{},
+		&ultraclient.ExponentialBackoff{},
+	)
+
+	interceptor = NewInterceptor(
+		uclient,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///myservice",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(interceptor.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(interceptor.StreamClientInterceptor()),
+	)
+	assert.Nil(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		interceptor.Close()
+		grpcServer.Stop()
+	})
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryCallIsSentToEndpoint(t *testing.T) {
+	client := setupInterceptor(t)
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	assert.Equal(t, 1, server.calls)
+}
+
+func TestUnaryCallRetriesUnavailable(t *testing.T) {
+	client := setupInterceptor(t, status.Error(codes.Unavailable, "down"))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	assert.Equal(t, 2, server.calls)
+}
+
+func TestUnaryCallReturnsStatusWhenRetriesExhausted(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	client := setupInterceptor(t, unavailable, unavailable, unavailable)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, server.calls)
+}
+
+func TestUnaryCallDoesNotRetryNotFound(t *testing.T) {
+	client := setupInterceptor(t, status.Error(codes.NotFound, "unknown service"))
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, 1, server.calls)
+}
+
+func TestUnaryCallDoesNotRetryInternal(t *testing.T) {
+	client := setupInterceptor(t, status.Error(codes.Internal, "boom"))
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, 1, server.calls)
+}
+
+func TestUnaryCallWaitsForPushbackOnResourceExhausted(t *testing.T) {
+	client := setupInterceptor(t, status.Error(codes.ResourceExhausted, "slow down"))
+	server.trailer = metadata.Pairs(pushbackKey, "50")
+
+	startTime := time.Now()
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, server.calls)
+	assert.True(t, time.Now().Sub(startTime) >= 50*time.Millisecond)
+}
+
+func TestStreamIsCreatedOnEndpoint(t *testing.T) {
+	client := setupInterceptor(t)
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+
+	resp, err := stream.Recv()
+
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestClassifyErrorDoesNotFailEndpointWhenCallerIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := classifyError(ctx, status.Error(codes.DeadlineExceeded, "deadline"), nil)
+
+	assert.IsType(t, ultraclient.BadRequestError{}, err)
+}
+
+func TestClassifyErrorRetriesDeadlineExceededFromServer(t *testing.T) {
+	err := status.Error(codes.DeadlineExceeded, "deadline")
+
+	assert.Equal(t, err, classifyError(context.Background(), err, nil))
+}
+
+func TestUnaryCallClosesConnectionsToRemovedEndpoints(t *testing.T) {
+	client := setupInterceptor(t)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+
+	uclient.UpdateEndpoints([]url.URL{url.URL{Host: "server2:9090"}})
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+
+	interceptor.Lock()
+	defer interceptor.Unlock()
+
+	assert.Len(t, interceptor.conns, 1)
+	assert.Contains(t, interceptor.conns, "server2:9090")
+}
+
+func TestUnaryCallPassesAttemptTraceToInvoker(t *testing.T) {
+	setupInterceptor(t)
+
+	tracer := tracetest.NewTracer()
+	uclient.RegisterTracer(tracer)
+
+	var downstream context.Context
+	invoker := func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		opts ...grpc.CallOption) error {
+
+		downstream = ctx
+		return nil
+	}
+
+	ctx, _ := tracer.Start(context.Background(), "caller")
+	err := interceptor.UnaryClientInterceptor()(
+		ctx, "/grpc.health.v1.Health/Check", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{}, nil, invoker)
+
+	assert.Nil(t, err)
+	tracetest.AssertLinked(t, tracer, downstream)
+}
@@ -0,0 +1,285 @@
+// Package ultrahttp provides a net/http RoundTripper which sends requests
+// through an ultraclient.Client, giving http.Client loadbalancing, circuit
+// breaking and retries.
+package ultrahttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+)
+
+// ErrRequestNotRetryable is returned from an attempt when a request which has
+// already been sent can not be safely sent again, this happens for non
+// idempotent methods or when the request body can not be rewound.  The attempt
+// is not counted against the endpoint and the caller receives the error of the
+// first attempt.
+var ErrRequestNotRetryable = errors.New("request has been sent and can not be retried")
+
+// StatusError is returned when the upstream responds with a status code
+// which the RoundTripper treats as a failure.
+type StatusError struct {
+	// StatusCode is the http status code returned by the upstream
+	StatusCode int
+
+	// Status is the http status text returned by the upstream
+	Status string
+}
+
+// Error implements the error interface
+func (s StatusError) Error() string {
+	return fmt.Sprintf("upstream returned status: %v", s.Status)
+}
+
+// RoundTripper is an http.RoundTripper which uses an ultraclient.Client to
+// pick the endpoint for each attempt, the scheme and host of the outgoing
+// request are replaced with those of the endpoint and the path of the endpoint
+// is joined with the path of the request.
+// 5xx and 429 responses are retried, 4xx responses are returned to the
+// caller without retrying.
+// client := &http.Client{Transport: ultrahttp.NewRoundTripper(ultraclient)}
+// resp, err := client.Get("http://myservice/health")
+type RoundTripper struct {
+	// Transport is used to make each attempt, when nil http.DefaultTransport
+	// is used.
+	Transport http.RoundTripper
+
+	// RetryNonIdempotent allows requests with methods which are not
+	// idempotent, such as POST, to be retried.
+	RetryNonIdempotent bool
+
+	client ultraclient.Client
+}
+
+// NewRoundTripper creates a new RoundTripper which sends requests through the
+// given client
+func NewRoundTripper(client ultraclient.Client) *RoundTripper {
+	return &RoundTripper{client: client}
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := r.canRetry(req)
+	result := ultraclient.NewFirstResult(drainBody)
+	first := &firstAttempt{}
+
+	var attempts int32
+	var skipped atomic.Bool
+	err := r.client.DoContext(req.Context(), func(ctx context.Context, endpoint url.URL) error {
+		attempt := atomic.AddInt32(&attempts, 1)
+		if attempt == 1 {
+			first.start(endpoint)
+		}
+
+		// the request is not sent so the endpoint must not be blamed
+		if attempt > 1 && !retryable {
+			skipped.Store(true)
+			return ultraclient.BadRequest(ErrRequestNotRetryable)
+		}
+
+		outreq, err := rewriteRequest(ctx, req, endpoint, attempt)
+		if err != nil {
+			skipped.Store(true)
+			return ultraclient.BadRequest(err)
+		}
+
+		resp, err := r.transport().RoundTrip(outreq)
+		if err == nil {
+			err = statusError(resp)
+			if err != nil {
+				drainBody(resp)
+			}
+		}
+
+		if attempt == 1 {
+			first.finish(err)
+		}
+
+		if err != nil {
+			if !retryable {
+				return ultraclient.NonRetryable(err)
+			}
+
+			return err
+		}
+
+		result.Set(resp)
+		return nil
+	})
+
+	resp := result.Finish(err)
+	if err != nil {
+		if skipped.Load() {
+			err = first.error()
+		}
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (r *RoundTripper) transport() http.RoundTripper {
+	if r.Transport == nil {
+		return http.DefaultTransport
+	}
+
+	return r.Transport
+}
+
+func (r *RoundTripper) canRetry(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	return r.RetryNonIdempotent || isIdempotent(req)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	// follow net/http which treats requests with an idempotency key as
+	// idempotent
+	_, key := req.Header["Idempotency-Key"]
+	_, xkey := req.Header["X-Idempotency-Key"]
+
+	return key || xkey
+}
+
+// rewriteRequest clones the request for the attempt with the context of the
+// attempt, which is derived from the context of the request
+func rewriteRequest(ctx context.Context, req *http.Request, endpoint url.URL, attempt int32) (*http.Request, error) {
+	outreq := req.Clone(ctx)
+
+	if attempt > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+
+		outreq.Body = body
+	}
+
+	if endpoint.Scheme != "" {
+		outreq.URL.Scheme = endpoint.Scheme
+	}
+
+	outreq.URL.Host = endpoint.Host
+	outreq.Host = endpoint.Host
+
+	if endpoint.Path != "" {
+		outreq.URL.Path = joinPath(endpoint.Path, req.URL.Path)
+		if endpoint.RawPath != "" || req.URL.RawPath != "" {
+			outreq.URL.RawPath = joinPath(endpoint.EscapedPath(), req.URL.EscapedPath())
+		}
+	}
+
+	return outreq, nil
+}
+
+// joinPath joins the path of the endpoint and the path of the request with a
+// single slash
+func joinPath(base, path string) string {
+	switch {
+	case strings.HasSuffix(base, "/") && strings.HasPrefix(path, "/"):
+		return base + path[1:]
+	case !strings.HasSuffix(base, "/") && !strings.HasPrefix(path, "/") && path != "":
+		return base + "/" + path
+	}
+
+	return base + path
+}
+
+// firstAttempt records the endpoint and error of the first attempt, when a
+// later attempt can not send the request the caller receives the error of the
+// first attempt rather than ErrRequestNotRetryable
+type firstAttempt struct {
+	sync.Mutex
+	endpoint url.URL
+	err      error
+	returned bool
+}
+
+func (f *firstAttempt) start(endpoint url.URL) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.endpoint = endpoint
+}
+
+func (f *firstAttempt) finish(err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.err = err
+	f.returned = true
+}
+
+// error returns the error the client reported for the first attempt, when the
+// attempt had not failed the client moved on because it timed out
+func (f *firstAttempt) error() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if !f.returned || f.err == nil {
+		return ultraclient.ClientError{Message: ultraclient.ErrorTimeout, URL: f.endpoint}
+	}
+
+	return ultraclient.ClientError{Message: f.err.Error(), URL: f.endpoint, Err: f.err}
+}
+
+func statusError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return nil
+	}
+
+	err := StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		return ultraclient.RetryAfter(err, delay)
+	}
+
+	return err
+}
+
+// parseRetryAfter parses the Retry-After header which can either be a number
+// of seconds or an http date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(time.Now())
+		if delay < 0 {
+			delay = 0
+		}
+
+		return delay, true
+	}
+
+	return 0, false
+}
+
+func drainBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
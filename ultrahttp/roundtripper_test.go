@@ -0,0 +1,309 @@
+package ultrahttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/nicholasjackson/ultraclient/internal/tracetest"
+	"github.com/stretchr/testify/assert"
+)
+
+var requests []*http.Request
+var bodies []string
+
+func setupServer(statusCodes ...int) (*httptest.Server, *http.Client) {
+	requests = []*http.Request{}
+	bodies = []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+
+		status := statusCodes[len(statusCodes)-1]
+		if len(requests) <= len(statusCodes) {
+			status = statusCodes[len(requests)-1]
+		}
+
+		rw.WriteHeader(status)
+	}))
+
+	u, _ := url.Parse(server.URL)
+
+	client := ultraclient.NewClient(
+		ultraclient.Config{
+			Timeout:                1 * time.Second,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  100,
+			DefaultVolumeThreshold: 100,
+			Retries:                2,
+			RetryDelay:             1 * time.Millisecond,
+			Endpoints:              []url.URL{*u},
+		},
+		&ultraclient.RoundRobinStrategy{},
+		&ultraclient.ExponentialBackoff{},
+	)
+
+	return server, &http.Client{Transport: NewRoundTripper(client)}
+}
+
+func TestRoundTripRewritesHostToEndpoint(t *testing.T) {
+	server, client := setupServer(http.StatusOK)
+	defer server.Close()
+
+	resp, err := client.Get("http://myservice/health")
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/health", requests[0].URL.Path)
+	assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), requests[0].Host)
+}
+
+func TestRoundTripRetriesServerErrors(t *testing.T) {
+	server, client := setupServer(http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+
+	resp, err := client.Get("http://myservice/health")
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, len(requests))
+}
+
+func TestRoundTripReturnsErrorWhenRetriesExhausted(t *testing.T) {
+	server, client := setupServer(http.StatusInternalServerError)
+	defer server.Close()
+
+	_, err := client.Get("http://myservice/health")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, len(requests))
+}
+
+func TestRoundTripDoesNotRetryClientErrors(t *testing.T) {
+	server, client := setupServer(http.StatusNotFound)
+	defer server.Close()
+
+	resp, err := client.Get("http://myservice/health")
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, 1, len(requests))
+}
+
+func TestRoundTripDoesNotRetryNonIdempotentMethods(t *testing.T) {
+	server, client := setupServer(http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+
+	_, err := client.Post("http://myservice/orders", "text/plain", strings.NewReader("hello"))
+
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, 1, len(requests))
+}
+
+func TestRoundTripRewindsBodyWhenRetryingNonIdempotentMethods(t *testing.T) {
+	server, client := setupServer(http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+	client.Transport.(*RoundTripper).RetryNonIdempotent = true
+
+	resp, err := client.Post("http://myservice/orders", "text/plain", strings.NewReader("hello"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"hello", "hello"}, bodies)
+}
+
+func TestRoundTripReturnsFirstErrorWhenNonIdempotentRequestTimesOut(t *testing.T) {
+	var attempts int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	u, _ := url.Parse(server.URL)
+	uc := ultraclient.NewClient(
+		ultraclient.Config{
+			Timeout:                20 * time.Millisecond,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  100,
+			DefaultVolumeThreshold: 100,
+			Retries:                2,
+			RetryDelay:             1 * time.Millisecond,
+			Endpoints:              []url.URL{*u},
+		},
+		&ultraclient.RoundRobinStrategy{},
+		&ultraclient.ExponentialBackoff{},
+	)
+	client := &http.Client{Transport: NewRoundTripper(uc)}
+
+	_, err := client.Post("http://myservice/orders", "text/plain", strings.NewReader("hello"))
+
+	var clientErr ultraclient.ClientError
+	assert.True(t, errors.As(err, &clientErr))
+	assert.Equal(t, ultraclient.ErrorTimeout, clientErr.Message)
+	assert.False(t, errors.Is(err, ErrRequestNotRetryable))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestRoundTripReturnsFirstErrorWhenBodyCanNotBeRewound(t *testing.T) {
+	server, client := setupServer(http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, "http://myservice/orders/1", strings.NewReader("hello"))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return nil, fmt.Errorf("body has gone")
+	}
+
+	_, err := client.Do(req)
+
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, 1, len(requests))
+}
+
+func TestRoundTripJoinsEndpointPathWithRequestPath(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/api")
+	uc := ultraclient.NewClient(
+		ultraclient.Config{
+			Timeout:                1 * time.Second,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  100,
+			DefaultVolumeThreshold: 100,
+			Endpoints:              []url.URL{*u},
+		},
+		&ultraclient.RoundRobinStrategy{},
+		&ultraclient.ExponentialBackoff{},
+	)
+	client := &http.Client{Transport: NewRoundTripper(uc)}
+
+	resp, err := client.Get("http://myservice/health")
+
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "/api/health", path)
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := map[[2]string]string{
+		{"/api", "/health"}:  "/api/health",
+		{"/api/", "/health"}: "/api/health",
+		{"/api", "health"}:   "/api/health",
+		{"/api/", ""}:        "/api/",
+		{"/api", ""}:         "/api",
+	}
+
+	for paths, expected := range tests {
+		assert.Equal(t, expected, joinPath(paths[0], paths[1]))
+	}
+}
+
+func TestRoundTripStopsRetryingWhenRequestContextIsCancelled(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		rw.Header().Set("Retry-After", "1")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	uc := ultraclient.NewClient(
+		ultraclient.Config{
+			Timeout:                1 * time.Second,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  100,
+			DefaultVolumeThreshold: 100,
+			Retries:                2,
+			RetryDelay:             1 * time.Millisecond,
+			Endpoints:              []url.URL{*u},
+		},
+		&ultraclient.RoundRobinStrategy{},
+		&ultraclient.ExponentialBackoff{},
+	)
+	client := &http.Client{Transport: NewRoundTripper(uc)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://myservice/health", nil)
+
+	start := time.Now()
+	_, err := client.Do(req)
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestParseRetryAfterParsesSeconds(t *testing.T) {
+	delay, ok := parseRetryAfter("2")
+
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, delay)
+}
+
+func TestParseRetryAfterParsesDate(t *testing.T) {
+	date := time.Now().Add(1 * time.Minute).UTC().Format(http.TimeFormat)
+
+	delay, ok := parseRetryAfter(date)
+
+	assert.True(t, ok)
+	assert.True(t, delay > 50*time.Second)
+}
+
+func TestParseRetryAfterIgnoresInvalidValues(t *testing.T) {
+	_, ok := parseRetryAfter("soon")
+
+	assert.False(t, ok)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (r roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r(req)
+}
+
+func TestRoundTripPassesAttemptTraceToRequest(t *testing.T) {
+	server, client := setupServer(http.StatusOK)
+	defer server.Close()
+
+	tracer := tracetest.NewTracer()
+	rt := client.Transport.(*RoundTripper)
+	rt.client.RegisterTracer(tracer)
+
+	var downstream context.Context
+	rt.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		downstream = req.Context()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	ctx, _ := tracer.Start(context.Background(), "caller")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://myservice/health", nil)
+
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	tracetest.AssertLinked(t, tracer, downstream)
+}
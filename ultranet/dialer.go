@@ -0,0 +1,76 @@
+// Package ultranet provides a net Dialer which establishes connections
+// through an ultraclient.Client, for use with raw TCP protocols such as Redis
+// or Memcached.
+package ultranet
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/nicholasjackson/ultraclient"
+)
+
+// Dialer establishes connections to the endpoint chosen by the loadbalancer,
+// connection failures are retried and count towards opening the circuit for
+// the endpoint.  Dial latency and failures are recorded with the stats
+// registered with the client.
+// dialer := ultranet.NewDialer(client)
+// conn, err := dialer.DialContext(ctx, "tcp", "")
+type Dialer struct {
+	// Dialer is used to establish each connection, when nil a zero value
+	// net.Dialer is used.
+	Dialer *net.Dialer
+
+	client ultraclient.Client
+}
+
+// NewDialer creates a new Dialer which establishes connections through the
+// given client
+func NewDialer(client ultraclient.Client) *Dialer {
+	return &Dialer{client: client}
+}
+
+// Dial connects to the endpoint chosen by the loadbalancer, the address is
+// ignored.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the endpoint chosen by the loadbalancer, the context
+// bounds every attempt and the backoff between them, the address is ignored.
+// The signature matches the dial function accepted by most database and cache
+// clients.
+func (d *Dialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	result := ultraclient.NewFirstResult(func(conn net.Conn) { conn.Close() })
+
+	err := d.client.DoContext(ctx, func(ctx context.Context, endpoint url.URL) error {
+		conn, err := d.dialer().DialContext(ctx, network, endpoint.Host)
+		if err != nil {
+			// a cancelled context is not a failure of the endpoint
+			if ctx.Err() != nil {
+				return ultraclient.BadRequest(ctx.Err())
+			}
+
+			return err
+		}
+
+		result.Set(conn)
+		return nil
+	})
+
+	conn := result.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (d *Dialer) dialer() *net.Dialer {
+	if d.Dialer == nil {
+		return &net.Dialer{}
+	}
+
+	return d.Dialer
+}
@@ -0,0 +1,175 @@
+package ultranet
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/nicholasjackson/ultraclient"
+	"github.com/nicholasjackson/ultraclient/internal/tracetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var mockStats *ultraclient.MockStats
+
+func setupDialer(t *testing.T, endpoints ...url.URL) *Dialer {
+	hystrix.Flush()
+
+	index := 0
+	var next ultraclient.GetEndpoint = func() url.URL {
+		endpoint := endpoints[index%len(endpoints)]
+		index++
+		return endpoint
+	}
+
+	lb := &ultraclient.MockLoadbalancingStrategy{}
+	lb.On("SetEndpoints", mock.Anything)
+	lb.On("NextEndpoint").Return(next)
+	lb.On("GetEndpoints").Return(endpoints)
+	lb.On("Length").Return(len(endpoints))
+
+	mockStats = &ultraclient.MockStats{}
+	mockStats.On("Timing", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockStats.On("Increment", mock.Anything, mock.Anything, mock.Anything)
+
+	client := ultraclient.NewClient(
+		ultraclient.Config{
+			Timeout:                1 * time.Second,
+			MaxConcurrentRequests:  10,
+			ErrorPercentThreshold:  100,
+			DefaultVolumeThreshold: 100,
+			Retries:                2,
+			RetryDelay:             1 * time.Millisecond,
+			Endpoints:              endpoints,
+			StatsD: ultraclient.StatsD{
+				Prefix: "redis.dial",
+			},
+		},
+		lb,
+		&ultraclient.ExponentialBackoff{},
+	)
+	client.RegisterStats(mockStats)
+
+	return NewDialer(client)
+}
+
+func setupListener(t *testing.T) url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Close()
+		}
+	}()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return url.URL{Host: listener.Addr().String()}
+}
+
+func closedEndpoint(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	l.Close()
+
+	return url.URL{Host: l.Addr().String()}
+}
+
+func TestDialContextConnectsToEndpoint(t *testing.T) {
+	endpoint := setupListener(t)
+	dialer := setupDialer(t, endpoint)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", "ignored:6379")
+
+	assert.Nil(t, err)
+	assert.Equal(t, endpoint.Host, conn.RemoteAddr().String())
+	conn.Close()
+}
+
+func TestDialContextRetriesNextEndpointOnFailure(t *testing.T) {
+	bad := closedEndpoint(t)
+	good := setupListener(t)
+	dialer := setupDialer(t, bad, good)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, good.Host, conn.RemoteAddr().String())
+	conn.Close()
+
+	mockStats.AssertCalled(t,
+		"Increment",
+		"redis.dial.error", []string{"server:" + ultraclient.PrettyPrintURL(&bad)}, mock.Anything)
+	mockStats.AssertCalled(t,
+		"Timing",
+		"redis.dial.timing", []string{"server:" + ultraclient.PrettyPrintURL(&good)}, mock.Anything, mock.Anything)
+}
+
+func TestDialContextDoesNotRetryCancelledContext(t *testing.T) {
+	dialer := setupDialer(t, closedEndpoint(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := dialer.DialContext(ctx, "tcp", "")
+
+	assert.NotNil(t, err)
+	mockStats.AssertNumberOfCalls(t, "Timing", 0)
+}
+
+func TestDialContextStopsRetryingWhenDeadlinePasses(t *testing.T) {
+	dialer := setupDialer(t, closedEndpoint(t))
+	dialer.client.(*ultraclient.ClientImpl).Reload(ultraclient.Config{
+		Timeout:                1 * time.Second,
+		MaxConcurrentRequests:  10,
+		ErrorPercentThreshold:  100,
+		DefaultVolumeThreshold: 100,
+		Retries:                2,
+		RetryDelay:             1 * time.Second,
+		Endpoints:              dialer.client.Endpoints(),
+		StatsD:                 ultraclient.StatsD{Prefix: "redis.dial"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := dialer.DialContext(ctx, "tcp", "")
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	mockStats.AssertNumberOfCalls(t, "Timing", 1)
+}
+
+func TestDialContextPassesAttemptTraceToDial(t *testing.T) {
+	dialer := setupDialer(t, setupListener(t))
+
+	tracer := tracetest.NewTracer()
+	dialer.client.RegisterTracer(tracer)
+
+	var downstream context.Context
+	dialer.Dialer = &net.Dialer{
+		ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+			downstream = ctx
+			return nil
+		},
+	}
+
+	ctx, _ := tracer.Start(context.Background(), "caller")
+	conn, err := dialer.DialContext(ctx, "tcp", "")
+
+	assert.Nil(t, err)
+	conn.Close()
+	tracetest.AssertLinked(t, tracer, downstream)
+}
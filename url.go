@@ -6,6 +6,28 @@ import (
 	"strings"
 )
 
+// EndpointMetadata is the weight, zone and labels given to an endpoint by
+// service discovery, it is passed to strategies which implement
+// WeightedLoadbalancingStrategy and is never added to the endpoint itself.
+type EndpointMetadata struct {
+	// Weight is the share of requests the endpoint receives relative to the
+	// other endpoints, endpoints with a weight of zero only receive requests
+	// when every endpoint has a weight of zero
+	Weight int
+
+	// Zone is the zone of the endpoint, empty when not known
+	Zone string
+
+	// Labels is any other metadata of the endpoint
+	Labels map[string]string
+}
+
+// Endpoint is an endpoint found by a Discoverer with its metadata
+type Endpoint struct {
+	URL      url.URL
+	Metadata EndpointMetadata
+}
+
 // PrettyPrintURL is a helper function to pretty print a url in a format
 // suitable for statsd
 func PrettyPrintURL(url *url.URL) string {
@@ -17,3 +39,32 @@ func PrettyPrintURL(url *url.URL) string {
 
 	return fmt.Sprintf("%v_%v", parts[0], parts[1])
 }
+
+// defaultMetadata returns the metadata of endpoints which were not found by
+// service discovery, each endpoint has a weight of 1
+func defaultMetadata(endpoints []url.URL) []EndpointMetadata {
+	if endpoints == nil {
+		return nil
+	}
+
+	metadata := make([]EndpointMetadata, len(endpoints))
+	for i := range metadata {
+		metadata[i] = EndpointMetadata{Weight: 1}
+	}
+
+	return metadata
+}
+
+// splitEndpoints returns the urls and the metadata of the discovered
+// endpoints in the same order
+func splitEndpoints(endpoints []Endpoint) ([]url.URL, []EndpointMetadata) {
+	urls := make([]url.URL, len(endpoints))
+	metadata := make([]EndpointMetadata, len(endpoints))
+
+	for i, endpoint := range endpoints {
+		urls[i] = endpoint.URL
+		metadata[i] = endpoint.Metadata
+	}
+
+	return urls, metadata
+}
@@ -22,3 +22,19 @@ func TestPrettyPrintsURLWithPort(t *testing.T) {
 
 	assert.Equal(t, "localhost_3232", s)
 }
+
+func TestSplitEndpointsReturnsURLsAndMetadata(t *testing.T) {
+	urls, metadata := splitEndpoints([]Endpoint{
+		{URL: url.URL{Host: "localhost:3232"}, Metadata: EndpointMetadata{Weight: 10, Zone: "eu-west-1a"}},
+		{URL: url.URL{Host: "localhost:3233"}},
+	})
+
+	assert.Equal(t, []url.URL{{Host: "localhost:3232"}, {Host: "localhost:3233"}}, urls)
+	assert.Equal(t, []EndpointMetadata{{Weight: 10, Zone: "eu-west-1a"}, {}}, metadata)
+}
+
+func TestDefaultMetadataGivesEachEndpointAWeightOfOne(t *testing.T) {
+	metadata := defaultMetadata([]url.URL{{Host: "localhost:3232"}, {Host: "localhost:3233"}})
+
+	assert.Equal(t, []EndpointMetadata{{Weight: 1}, {Weight: 1}}, metadata)
+}
@@ -0,0 +1,118 @@
+package ultraclient
+
+import (
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+// WeightedRoundRobinStrategy is a load balancing strategy which shares the
+// requests between the endpoints in proportion to the weights given to them by
+// service discovery, the requests are spread evenly rather than sent to each
+// endpoint in turn.  When Zone is set and any endpoint in the zone has a
+// weight the requests are only sent to endpoints in the zone.
+type WeightedRoundRobinStrategy struct {
+	// Zone is the zone of the client
+	Zone string
+
+	endpoints  []url.URL
+	metadata   []EndpointMetadata
+	weights    []int
+	current    []int
+	candidates []int
+}
+
+// NextEndpoint returns the endpoint furthest behind its share of the requests
+func (w *WeightedRoundRobinStrategy) NextEndpoint() url.URL {
+	if len(w.candidates) == 0 {
+		return url.URL{}
+	}
+
+	best := w.candidates[0]
+	total := 0
+	for _, i := range w.candidates {
+		w.current[i] += w.weights[i]
+		total += w.weights[i]
+
+		if w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+
+	w.current[best] -= total
+	return w.endpoints[best]
+}
+
+// SetEndpoints sets the available endpoints for use by the strategy, each
+// endpoint has a weight of 1
+func (w *WeightedRoundRobinStrategy) SetEndpoints(endpoints []url.URL) {
+	w.SetWeightedEndpoints(endpoints, nil)
+}
+
+// SetWeightedEndpoints sets the available endpoints with the weight and zone
+// of each endpoint, endpoints without metadata have a weight of 1
+func (w *WeightedRoundRobinStrategy) SetWeightedEndpoints(endpoints []url.URL, metadata []EndpointMetadata) {
+	w.endpoints = endpoints
+	w.metadata = metadata
+	w.weights = make([]int, len(endpoints))
+	w.current = make([]int, len(endpoints))
+	w.candidates = nil
+
+	var all, zoned []int
+	for i := range endpoints {
+		w.weights[i] = 1
+		if i < len(metadata) {
+			w.weights[i] = metadata[i].Weight
+		}
+
+		all = append(all, i)
+		if w.Zone != "" && i < len(metadata) && metadata[i].Zone == w.Zone && w.weights[i] > 0 {
+			zoned = append(zoned, i)
+		}
+	}
+
+	pool := all
+	if len(zoned) > 0 {
+		pool = zoned
+	}
+
+	for _, i := range pool {
+		if w.weights[i] > 0 {
+			w.candidates = append(w.candidates, i)
+		}
+	}
+
+	// endpoints are shared equally when none of them has a weight
+	if len(w.candidates) == 0 {
+		for _, i := range pool {
+			w.weights[i] = 1
+		}
+
+		w.candidates = pool
+	}
+
+	// clones start from a random point in the sequence so they do not all
+	// send their first request to the same endpoint
+	ra := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for skip := ra.Intn(len(w.candidates) + 1); skip > 0; skip-- {
+		w.NextEndpoint()
+	}
+}
+
+// GetEndpoints returns every endpoint including those outside the zone
+func (w *WeightedRoundRobinStrategy) GetEndpoints() []url.URL {
+	return w.endpoints
+}
+
+// Length returns the number of endpoints
+func (w *WeightedRoundRobinStrategy) Length() int {
+	return len(w.endpoints)
+}
+
+// Clone creates a clone of this strategy
+func (w *WeightedRoundRobinStrategy) Clone() LoadbalancingStrategy {
+	ws := &WeightedRoundRobinStrategy{Zone: w.Zone}
+	ws.SetWeightedEndpoints(w.endpoints, w.metadata)
+
+	return ws
+}
@@ -0,0 +1,152 @@
+package ultraclient
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var weightedEndpoints = []url.URL{
+	url.URL{Host: "host1"},
+	url.URL{Host: "host2"},
+	url.URL{Host: "host3"},
+}
+
+func countEndpoints(strategy LoadbalancingStrategy, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		endpoint := strategy.NextEndpoint()
+		counts[endpoint.Host]++
+	}
+
+	return counts
+}
+
+func TestWeightedSharesRequestsByWeight(t *testing.T) {
+	strategy := &WeightedRoundRobinStrategy{}
+	strategy.SetWeightedEndpoints(weightedEndpoints, []EndpointMetadata{
+		{Weight: 1},
+		{Weight: 3},
+		{Weight: 0},
+	})
+
+	counts := countEndpoints(strategy, 40)
+
+	assert.Equal(t, map[string]int{"host1": 10, "host2": 30}, counts)
+}
+
+func TestWeightedSpreadsRequestsEvenly(t *testing.T) {
+	strategy := &WeightedRoundRobinStrategy{}
+	strategy.SetWeightedEndpoints(weightedEndpoints[:2], []EndpointMetadata{{Weight: 1}, {Weight: 1}})
+
+	first := strategy.NextEndpoint()
+	second := strategy.NextEndpoint()
+
+	assert.NotEqual(t, first, second)
+}
+
+func TestWeightedSharesEquallyWithoutWeights(t *testing.T) {
+	strategy := &WeightedRoundRobinStrategy{}
+	strategy.SetWeightedEndpoints(weightedEndpoints, []EndpointMetadata{{}, {}, {}})
+
+	counts := countEndpoints(strategy, 30)
+
+	assert.Equal(t, map[string]int{"host1": 10, "host2": 10, "host3": 10}, counts)
+}
+
+func TestWeightedPrefersEndpointsInZone(t *testing.T) {
+	strategy := &WeightedRoundRobinStrategy{Zone: "eu-west-1a"}
+	strategy.SetWeightedEndpoints(weightedEndpoints, []EndpointMetadata{
+		{Weight: 1, Zone: "eu-west-1a"},
+		{Weight: 1, Zone: "eu-west-1b"},
+		{Weight: 1, Zone: "eu-west-1a"},
+	})
+
+	counts := countEndpoints(strategy, 10)
+
+	assert.Equal(t, map[string]int{"host1": 5, "host3": 5}, counts)
+	assert.Equal(t, weightedEndpoints, strategy.GetEndpoints())
+}
+
+func TestWeightedUsesEveryZoneWhenZoneHasNoEndpoints(t *testing.T) {
+	strategy := &WeightedRoundRobinStrategy{Zone: "eu-west-1c"}
+	strategy.SetWeightedEndpoints(weightedEndpoints, []EndpointMetadata{
+		{Weight: 1, Zone: "eu-west-1a"},
+		{Weight: 1, Zone: "eu-west-1b"},
+		{Weight: 1, Zone: "eu-west-1a"},
+	})
+
+	counts := countEndpoints(strategy, 9)
+
+	assert.Equal(t, map[string]int{"host1": 3, "host2": 3, "host3": 3}, counts)
+}
+
+func TestWeightedCloneCreatesNewStrategy(t *testing.T) {
+	strategy := &WeightedRoundRobinStrategy{Zone: "eu-west-1a"}
+	strategy.SetWeightedEndpoints(weightedEndpoints, []EndpointMetadata{{Weight: 2}, {Weight: 1}, {Weight: 1}})
+
+	clone := strategy.Clone().(*WeightedRoundRobinStrategy)
+
+	assert.NotEqual(t, fmt.Sprintf("%p", strategy), fmt.Sprintf("%p", clone))
+	assert.Equal(t, strategy.Zone, clone.Zone)
+	assert.Equal(t, map[string]int{"host1": 20, "host2": 10, "host3": 10}, countEndpoints(clone, 40))
+}
+
+func setupWeightedClient(t *testing.T) (*ClientImpl, *WeightedRoundRobinStrategy) {
+	strategy := &WeightedRoundRobinStrategy{}
+
+	config := validConfig()
+	config.Retries = 0
+	c, err := New(WithConfig(config), WithStrategy(strategy))
+	assert.Nil(t, err)
+
+	return c.(*ClientImpl), strategy
+}
+
+func TestDiscoveredMetadataIsPassedToWeightedStrategy(t *testing.T) {
+	c, strategy := setupWeightedClient(t)
+
+	c.applyDiscovered([]Endpoint{
+		{URL: url.URL{Host: "weighted1:8080"}, Metadata: EndpointMetadata{Weight: 3}},
+		{URL: url.URL{Host: "weighted2:8080"}, Metadata: EndpointMetadata{Weight: 1, Zone: "eu-west-1a"}},
+	})
+
+	counts := map[url.URL]int{}
+	for i := 0; i < 8; i++ {
+		c.Do(func(endpoint url.URL) error {
+			counts[endpoint]++
+			return nil
+		})
+	}
+
+	assert.Equal(t, map[url.URL]int{{Host: "weighted1:8080"}: 6, {Host: "weighted2:8080"}: 2}, counts)
+	assert.Equal(t, []url.URL{{Host: "weighted1:8080"}, {Host: "weighted2:8080"}}, c.Endpoints())
+	assert.Equal(t, []EndpointMetadata{{Weight: 3}, {Weight: 1, Zone: "eu-west-1a"}}, strategy.metadata)
+}
+
+func TestDiscoveredWeightChangesArePassedToWeightedStrategy(t *testing.T) {
+	c, strategy := setupWeightedClient(t)
+
+	endpoint := url.URL{Host: "weighted1:8080"}
+	c.applyDiscovered([]Endpoint{{URL: endpoint, Metadata: EndpointMetadata{Weight: 1}}})
+	c.endpoints()
+
+	c.applyDiscovered([]Endpoint{{URL: endpoint, Metadata: EndpointMetadata{Weight: 5}}})
+	c.endpoints()
+
+	assert.Equal(t, []EndpointMetadata{{Weight: 5}}, strategy.metadata)
+}
+
+func TestConfiguredEndpointsAreGivenAWeightOfOne(t *testing.T) {
+	c, strategy := setupWeightedClient(t)
+
+	config := validConfig()
+	config.Endpoints = []url.URL{{Host: "weighted1:8080", RawQuery: "weight=5"}}
+	assert.Nil(t, c.Reload(config))
+	c.endpoints()
+
+	assert.Equal(t, []EndpointMetadata{{Weight: 1}}, strategy.metadata)
+	assert.Equal(t, config.Endpoints, c.Endpoints())
+}